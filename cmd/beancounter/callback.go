@@ -0,0 +1,361 @@
+/*OAuth callback transport; HTTPS server or pasted URL*/
+package main
+
+import (
+	"bufio"
+	gocontext "context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+const (
+	// selfSignedLifetime is how long a generated certificate is valid, it
+	// only has to last for one link
+	selfSignedLifetime = 24 * time.Hour
+
+	// callbackShutdown is how long we give the browser to collect its page
+	// once the flow is over
+	callbackShutdown = 5 * time.Second
+)
+
+// callbackPage is shown to the browser that's redirected to us
+var callbackPage = template.Must(template.New("callback").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>beancounter: {{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+// callbackResult is the outcome of an OAuth flow
+type callbackResult struct {
+	Token *domain.Token
+	Err   error
+}
+
+// callbackHandler handles the provider redirecting the user's browser back to
+// us. The first callback that settles the flow, be it a token or an error
+// from the provider, is the result; nothing sent after that is acted on.
+type callbackHandler struct {
+	states *oauthStates
+
+	// token swaps a code for a token
+	token func(code, verifier string) (*domain.Token, error)
+
+	lock     sync.Mutex
+	finished bool
+	result   chan *callbackResult // buffered, holds the one result
+}
+
+func newCallbackHandler(states *oauthStates, token func(code, verifier string) (*domain.Token, error)) *callbackHandler {
+	return &callbackHandler{
+		states: states,
+		token:  token,
+		result: make(chan *callbackResult, 1),
+	}
+}
+
+// Result is sent the outcome of the flow, once
+func (h *callbackHandler) Result() <-chan *callbackResult {
+	return h.result
+}
+
+func (h *callbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/favicon.ico" {
+		http.NotFound(w, r) // *sigh*
+		return
+	}
+	if h.isFinished() {
+		page(w, http.StatusGone, "Already linked", "This link has already been completed, you can close this window.")
+		return
+	}
+	fmt.Println("recieved message at:", r.URL.Path)
+
+	// a request we can't verify may not be from the provider at all (even
+	// one saying linking failed), so we keep waiting for one that is
+	code, verifier, err := verifyCallback(h.states, r.URL.Query())
+	if _, ok := err.(*oauthError); ok {
+		page(w, http.StatusBadRequest, "Linking failed", err.Error())
+		h.finish(nil, err)
+		return
+	} else if err != nil {
+		fmt.Println("ignoring callback:", err)
+		page(w, http.StatusBadRequest, "Invalid request", err.Error())
+		return
+	}
+
+	fmt.Println("message verified, exchanging code for token")
+	tkn, err := h.token(code, verifier)
+	if err != nil {
+		// the state is spent, the flow has to be started over
+		err = fmt.Errorf("failed to get token: %v", err)
+		page(w, http.StatusBadGateway, "Linking failed", err.Error())
+		h.finish(nil, err)
+		return
+	}
+
+	page(w, http.StatusOK, "Linked", "Your account is linked, you can close this window & return to the terminal.")
+	h.finish(tkn, nil)
+}
+
+func (h *callbackHandler) isFinished() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.finished
+}
+
+// finish records the outcome of the flow, if it hasn't one already
+func (h *callbackHandler) finish(tkn *domain.Token, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.finished {
+		return
+	}
+	h.finished = true
+	h.result <- &callbackResult{Token: tkn, Err: err}
+}
+
+// serve runs srv on ln until the flow is settled or timeout passes, then
+// shuts the server down gracefully.
+func (h *callbackHandler) serve(srv *http.Server, ln net.Listener, timeout time.Duration) (*domain.Token, error) {
+	srv.Handler = h
+	if srv.ReadHeaderTimeout == 0 {
+		srv.ReadHeaderTimeout = 10 * time.Second
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	result := &callbackResult{}
+	select {
+	case result = <-h.result:
+	case err := <-failed:
+		result.Err = fmt.Errorf("callback server failed: %v", err)
+	case <-timer.C:
+		result.Err = fmt.Errorf("timed out after %v waiting for the OAuth callback", timeout)
+	}
+
+	// let in flight requests (the browser that sent us the token) finish
+	shutdown, cancel := gocontext.WithTimeout(gocontext.Background(), callbackShutdown)
+	defer cancel()
+	err := srv.Shutdown(shutdown)
+	if err != nil {
+		srv.Close()
+	}
+
+	return result.Token, result.Err
+}
+
+// verifyCallback checks the state of an OAuth callback, returning the code &
+// the PKCE code_verifier to exchange it with. An error the provider sent the
+// user back with is returned as an *oauthError, but only once the state
+// checks out.
+func verifyCallback(states *oauthStates, query url.Values) (string, string, error) {
+	blob := query.Get("state")
+	if blob == "" {
+		return "", "", fmt.Errorf("state not returned")
+	}
+	code := query.Get("code")
+	if code == "" && query.Get("error") == "" {
+		return "", "", fmt.Errorf("code not returned")
+	}
+
+	verifier, err := states.Verify(blob)
+	if err != nil {
+		return "", "", err
+	}
+	if query.Get("error") != "" {
+		return "", "", &oauthError{Code: query.Get("error"), Description: query.Get("error_description")}
+	}
+	return code, verifier, nil
+}
+
+// oauthError is an error the provider redirected the user with
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("provider returned error: %s", e.Code)
+	}
+	return fmt.Sprintf("provider returned error %s: %s", e.Code, e.Description)
+}
+
+// page writes a simple HTML page to the browser
+func page(w http.ResponseWriter, status int, title, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	callbackPage.Execute(w, map[string]string{"Title": title, "Message": message})
+}
+
+// callbackTLS returns the TLS config for our callback server, using the
+// given certificate & key files or else a freshly generated self signed
+// certificate for localhost (and host, if given). The SHA-256 fingerprint
+// of the certificate is returned so the user can check it in their browser.
+func callbackTLS(certFile, keyFile, host string) (*tls.Config, string, error) {
+	var cert tls.Certificate
+	var err error
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, "", fmt.Errorf("both a certificate & key are required")
+		}
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	} else {
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if host != "" && host != "localhost" {
+			hosts = append(hosts, host)
+		}
+		cert, err = selfSignedCert(hosts)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum := sha256.Sum256(cert.Certificate[0])
+	fingerprint := []string{}
+	for _, b := range sum {
+		fingerprint = append(fingerprint, fmt.Sprintf("%02X", b))
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, strings.Join(fingerprint, ":"), nil
+}
+
+// selfSignedCert generates a certificate valid for the given hosts (names
+// or IPs), the first of which is its common name.
+func selfSignedCert(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"beancounter"},
+			CommonName:   hosts[0],
+		},
+		NotBefore:             now.Add(-time.Hour), // allow for clock skew
+		NotAfter:              now.Add(selfSignedLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// readPastedCallback prompts for the URL the browser was sent to at the end
+// of the OAuth flow, until we're given one with a code & state (or an
+// error from the provider).
+func readPastedCallback(in io.Reader, out io.Writer) (url.Values, error) {
+	reader := bufio.NewReader(in)
+	for {
+		fmt.Fprint(out, "Paste the URL your browser was sent to: ")
+
+		line, err := reader.ReadString('\n')
+		if strings.TrimSpace(line) == "" && err != nil {
+			return nil, fmt.Errorf("no url given: %v", err)
+		}
+
+		query, perr := parseCallbackURL(line)
+		if perr == nil {
+			return query, nil
+		}
+		if err != nil {
+			return nil, perr // no more input
+		}
+		fmt.Fprintln(out, perr)
+	}
+}
+
+// parseCallbackURL reads the query of a callback URL, accepting the whole
+// URL or just the query string
+func parseCallbackURL(in string) (url.Values, error) {
+	in = strings.TrimSpace(in)
+
+	raw := in
+	if strings.Contains(in, "?") {
+		u, err := url.Parse(in)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url: %v", err)
+		}
+		raw = u.RawQuery
+	}
+
+	query, err := url.ParseQuery(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+
+	if query.Get("error") == "" && (query.Get("code") == "" || query.Get("state") == "") {
+		return nil, fmt.Errorf("url doesn't include a code & state, expected something like https://your-redirect/?code=...&state=...")
+	}
+	return query, nil
+}
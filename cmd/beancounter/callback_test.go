@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+func TestParseCallbackURL(t *testing.T) {
+	for _, in := range []string{
+		"https://localhost:8500/?code=abc&state=xyz.123",
+		"  https://example.com/callback?state=xyz.123&code=abc&scope=accounts\n",
+		"code=abc&state=xyz.123",
+	} {
+		query, err := parseCallbackURL(in)
+		assert.Nil(t, err, in)
+		assert.Equal(t, "abc", query.Get("code"), in)
+		assert.Equal(t, "xyz.123", query.Get("state"), in)
+	}
+}
+
+func TestParseCallbackURLProviderError(t *testing.T) {
+	query, err := parseCallbackURL("https://localhost:8500/?error=access_denied&error_description=nope")
+	assert.Nil(t, err)
+	assert.Equal(t, "access_denied", query.Get("error"))
+}
+
+func TestParseCallbackURLMissingParams(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"https://localhost:8500/",
+		"https://localhost:8500/?code=abc",
+		"state=xyz",
+		"https://localhost:8500/?code=%zz&state=xyz",
+	} {
+		_, err := parseCallbackURL(in)
+		assert.NotNil(t, err, in)
+	}
+}
+
+func TestReadPastedCallbackReprompts(t *testing.T) {
+	in := strings.NewReader("not a url\nhttps://localhost:8500/?code=abc&state=xyz\n")
+	out := &bytes.Buffer{}
+
+	query, err := readPastedCallback(in, out)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", query.Get("code"))
+	assert.Equal(t, 2, strings.Count(out.String(), "Paste the URL"))
+}
+
+func TestReadPastedCallbackNoNewline(t *testing.T) {
+	query, err := readPastedCallback(strings.NewReader("code=abc&state=xyz"), &bytes.Buffer{})
+	assert.Nil(t, err)
+	assert.Equal(t, "xyz", query.Get("state"))
+}
+
+func TestReadPastedCallbackEOF(t *testing.T) {
+	_, err := readPastedCallback(strings.NewReader("nonsense"), &bytes.Buffer{})
+	assert.NotNil(t, err)
+
+	_, err = readPastedCallback(strings.NewReader(""), &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
+
+func TestCallbackTLSSelfSigned(t *testing.T) {
+	cfg, fingerprint, err := callbackTLS("", "", "beans.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cfg.Certificates))
+	assert.Equal(t, 32*3-1, len(fingerprint))
+
+	leaf := cfg.Certificates[0].Leaf
+	for _, host := range []string{"localhost", "127.0.0.1", "::1", "beans.example.com"} {
+		assert.Nil(t, leaf.VerifyHostname(host), host)
+	}
+	assert.NotNil(t, leaf.VerifyHostname("elsewhere.example.com"))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: pool})
+	assert.Nil(t, err)
+}
+
+func TestCallbackTLSRequiresCertAndKey(t *testing.T) {
+	_, _, err := callbackTLS("cert.pem", "", "")
+	assert.NotNil(t, err)
+	_, _, err = callbackTLS("", "key.pem", "")
+	assert.NotNil(t, err)
+}
+
+// fakeToken stands in for the provider's token endpoint
+func fakeToken(err error) func(code, verifier string) (*domain.Token, error) {
+	return func(code, verifier string) (*domain.Token, error) {
+		if err != nil {
+			return nil, err
+		}
+		return domain.NewToken("access-"+code, "refresh", 3600), nil
+	}
+}
+
+func callback(t *testing.T, h http.Handler, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?"+query, nil))
+	return w
+}
+
+func TestCallbackHandlerSuccess(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+	blob, _, err := states.New()
+	assert.Nil(t, err)
+	h := newCallbackHandler(states, fakeToken(nil))
+
+	// strays are turned away without settling the flow
+	w := callback(t, h, "code=abc&state=nonsense")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 0, len(h.Result()))
+
+	w = callback(t, h, url.Values{"code": {"abc"}, "state": {blob}}.Encode())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Linked")
+
+	result := <-h.Result()
+	assert.Nil(t, result.Err)
+	assert.Equal(t, "access-abc", result.Token.Value)
+
+	// later hits don't block & aren't acted on
+	w = callback(t, h, url.Values{"code": {"abc"}, "state": {blob}}.Encode())
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, 0, len(h.Result()))
+}
+
+func TestCallbackHandlerProviderError(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+	blob, _, err := states.New()
+	assert.Nil(t, err)
+	h := newCallbackHandler(states, fakeToken(nil))
+
+	// anyone can send us an error, only the provider knows our state
+	w := callback(t, h, "error=access_denied")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = callback(t, h, "error=access_denied&state=nonsense")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 0, len(h.Result()))
+
+	w = callback(t, h, url.Values{
+		"error":             {"access_denied"},
+		"error_description": {"<b>user said no</b>"},
+		"state":             {blob},
+	}.Encode())
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "&lt;b&gt;user said no&lt;/b&gt;")
+
+	result := <-h.Result()
+	assert.Nil(t, result.Token)
+	assert.Contains(t, result.Err.Error(), "access_denied")
+}
+
+func TestCallbackHandlerTokenFailure(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+	blob, _, err := states.New()
+	assert.Nil(t, err)
+	h := newCallbackHandler(states, fakeToken(fmt.Errorf("bad code")))
+
+	w := callback(t, h, url.Values{"code": {"abc"}, "state": {blob}}.Encode())
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	result := <-h.Result()
+	assert.Contains(t, result.Err.Error(), "bad code")
+}
+
+func TestCallbackServe(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+	blob, _, err := states.New()
+	assert.Nil(t, err)
+	h := newCallbackHandler(states, fakeToken(nil))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	srv := &http.Server{}
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/?" + url.Values{"code": {"abc"}, "state": {blob}}.Encode())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+
+	tkn, err := h.serve(srv, ln, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "access-abc", tkn.Value)
+	assert.Contains(t, <-body, "Linked")
+
+	// and the server is gone
+	_, err = http.Get("http://" + ln.Addr().String() + "/")
+	assert.NotNil(t, err)
+}
+
+func TestCallbackServeTimeout(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+	h := newCallbackHandler(states, fakeToken(nil))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	_, err = h.serve(&http.Server{}, ln, 50*time.Millisecond)
+	assert.Contains(t, err.Error(), "timed out")
+}
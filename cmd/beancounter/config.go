@@ -0,0 +1,48 @@
+/*Config file loading & the config commands*/
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/voidshard/beancounter/pkg/config"
+)
+
+type configCmd struct {
+	Validate configValidateCmd `cmd help:"Check the config file, listing any problems found."`
+}
+
+type configValidateCmd struct{}
+
+func (v *configValidateCmd) Run(ctx *context) error {
+	cfg, err := ctx.loadConfig(true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is valid; %d providers, %d stores, %d connections, %d rules\n",
+		cfg.Filename(), len(cfg.Providers), len(cfg.Stores), len(cfg.Connections), len(cfg.Rules))
+	for _, name := range cfg.ConnectionNames() {
+		conn := cfg.Connections[name]
+		fmt.Printf("\t%s: %s, last %d days, writing to", name, conn.Provider, conn.Days)
+		for _, out := range cfg.Outs(conn) {
+			fmt.Printf(" %s", storeName(out))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// loadConfig reads the config file given by --config, or the default one.
+// If the config isn't required & no file was asked for, a missing default
+// file gives a nil config.
+func (c *context) loadConfig(required bool) (*config.Config, error) {
+	filename := c.Config
+	if filename == "" {
+		filename = config.DefaultPath()
+		if _, err := os.Stat(filename); os.IsNotExist(err) && !required {
+			return nil, nil
+		}
+	}
+	return config.Load(filename)
+}
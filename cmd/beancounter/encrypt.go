@@ -0,0 +1,183 @@
+/*Encryption at rest; passphrases & the encrypt, decrypt & rekey commands*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/voidshard/beancounter/pkg/crypto"
+	"github.com/voidshard/beancounter/pkg/fileutil"
+	"golang.org/x/term"
+)
+
+var (
+	// the passphrase is asked for at most once a run
+	passphraseOnce  sync.Once
+	passphraseValue []byte
+	passphraseErr   error
+)
+
+type encryptCmd struct {
+	File string `arg help:"File to encrypt, in place unless --out is given."`
+	Out  string `help:"Where to write the encrypted file."`
+}
+
+type decryptCmd struct {
+	File        string   `arg help:"File to decrypt."`
+	Out         string   `default:"-" help:"Where to write the plaintext, - for stdout."`
+	AgeIdentity []string `name:"age-identity" help:"Age identity file to decrypt with, for files encrypted to age recipients."`
+	PGPSecret   []string `name:"pgp-secret" help:"OpenPGP private key to decrypt with, for files encrypted to OpenPGP keys."`
+}
+
+type rekeyCmd struct {
+	Files []string `arg help:"Encrypted files (exports & vaults) to re-encrypt with a new passphrase."`
+}
+
+func (e *encryptCmd) Run(ctx *context) error {
+	data, err := ioutil.ReadFile(e.File)
+	if err != nil {
+		return err
+	}
+	if crypto.IsSealed(data) {
+		return fmt.Errorf("%s is already encrypted", e.File)
+	}
+
+	pass, err := readPassphrase("Passphrase: ", secretPassphrase, true)
+	if err != nil {
+		return err
+	}
+	sealed, err := crypto.Seal(data, pass)
+	if err != nil {
+		return err
+	}
+
+	out := e.Out
+	if out == "" {
+		out = e.File
+	}
+	return fileutil.WriteAtomic(out, sealed, 0600)
+}
+
+func (d *decryptCmd) Run(ctx *context) error {
+	sealed, err := ioutil.ReadFile(d.File)
+	if err != nil {
+		return err
+	}
+
+	// as a file store reads its output back, so that anything we wrote can
+	// be decrypted
+	cipher, err := storeCipher(url.Values{"age-identity": d.AgeIdentity, "pgp-secret": d.PGPSecret})
+	if err != nil {
+		return err
+	}
+	if cipher == nil {
+		pass, err := passphrase()
+		if err != nil {
+			return err
+		}
+		cipher = crypto.Passphrase(pass)
+	}
+	if !cipher.Sealed(sealed) {
+		return fmt.Errorf("%s isn't encrypted, or not in the format given", d.File)
+	}
+	data, err := cipher.Open(sealed)
+	if err != nil {
+		return err
+	}
+
+	if d.Out == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return fileutil.WriteAtomic(d.Out, data, 0600)
+}
+
+func (r *rekeyCmd) Run(ctx *context) error {
+	old, err := passphrase()
+	if err != nil {
+		return err
+	}
+	pass, err := readPassphrase("New passphrase: ", secretNewPassphrase, true)
+	if err != nil {
+		return err
+	}
+
+	// check we can open everything before changing anything
+	sealed := map[string][]byte{}
+	for _, filename := range r.Files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		_, err = crypto.Open(data, old)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		sealed[filename] = data
+	}
+
+	for _, filename := range r.Files {
+		data, err := crypto.Reseal(sealed[filename], old, pass)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		err = fileutil.WriteAtomic(filename, data, 0600)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		fmt.Println("Re-encrypted", filename)
+	}
+	return nil
+}
+
+// passphrase returns the passphrase for encrypted files, asking for it the
+// first time
+func passphrase() ([]byte, error) {
+	passphraseOnce.Do(func() {
+		passphraseValue, passphraseErr = readPassphrase("Passphrase: ", secretPassphrase, false)
+	})
+	return passphraseValue, passphraseErr
+}
+
+// readPassphrase reads the named passphrase from our secret sources (eg.
+// BEANCOUNTER_PASSPHRASE), or else from the terminal, asking twice if
+// confirm is set
+func readPassphrase(prompt, name string, confirm bool) ([]byte, error) {
+	value, err := secrets.Get(name)
+	if err == nil {
+		return []byte(value), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("passphrase required: %v", err)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("passphrase required")
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Again: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, fmt.Errorf("passphrases don't match")
+		}
+	}
+
+	return pass, nil
+}
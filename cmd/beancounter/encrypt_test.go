@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+func TestDecryptAgeIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	alice, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+	identity := filepath.Join(dir, "alice.txt")
+	assert.Nil(t, ioutil.WriteFile(identity, []byte(alice.String()+"\n"), 0600))
+
+	encrypted := filepath.Join(dir, "out.csv.age")
+	s, err := getStore("csv:" + encrypted + "?age=" + alice.Recipient().String())
+	assert.Nil(t, err)
+	assert.Nil(t, s.Write([]*domain.Transaction{&domain.Transaction{ID: "1", Description: "coffee", Timestamp: "2020-07-01T00:00:00Z"}}))
+
+	plain := filepath.Join(dir, "out.csv")
+	cmd := &decryptCmd{File: encrypted, Out: plain, AgeIdentity: []string{identity}}
+	assert.Nil(t, cmd.Run(nil))
+
+	data, err := ioutil.ReadFile(plain)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "coffee")
+
+	// a plaintext file isn't "decrypted"
+	cmd = &decryptCmd{File: plain, Out: filepath.Join(dir, "again.csv"), AgeIdentity: []string{identity}}
+	assert.NotNil(t, cmd.Run(nil))
+}
@@ -3,16 +3,28 @@ package main
 
 import (
 	"github.com/alecthomas/kong"
+	"time"
 )
 
 // context holds global options
-type context struct{}
+type context struct {
+	Secrets    []string `default:"env,file,keyring,pass" help:"Where to look for secrets not given as flags, in order [env file keyring pass]."`
+	SecretsDir string   `help:"Directory of secret files (default $XDG_CONFIG_HOME/beancounter/secrets)."`
+	Config     string   `help:"Config file of providers, connections, stores & rules (default $XDG_CONFIG_HOME/beancounter/config.yaml)."`
+}
 
 // cli commands / args available
 var cli struct {
 	Ctx context `embed`
 
-	Link linkCmd `cmd help:"Link a bank to beancounter."`
+	Link      linkCmd      `cmd help:"Link a bank to beancounter."`
+	Sync      syncCmd      `cmd help:"Fetch transactions for connections in the config file, linking any without a token."`
+	Serve     serveCmd     `cmd help:"Sync connections in the config file on their schedules, until stopped."`
+	Config    configCmd    `cmd help:"Work with the config file."`
+	Reprocess reprocessCmd `cmd help:"Parse archived provider replies again, writing the transactions out."`
+	Encrypt   encryptCmd   `cmd help:"Encrypt a file with a passphrase."`
+	Decrypt   decryptCmd   `cmd help:"Decrypt a file encrypted with a passphrase, or to age or OpenPGP keys."`
+	Rekey     rekeyCmd     `cmd help:"Re-encrypt files with a new passphrase."`
 }
 
 type linkCmd struct {
@@ -20,16 +32,31 @@ type linkCmd struct {
 }
 
 type truelayerCmd struct {
-	Port              int    `help:"Port to host HTTP server on (listens for Truelayer message)." default:8500`
-	Redirect          string `required help:"URL to have Truelayer send OAuth response to."`
-	TruelayerClientId string `name:"client-id" required help:"Truelayer client ID."`
-	TruelayerSecret   string `name:"secret" required help:"Truelayer client secret."`
-	Days              int    `default:1095 help:"Number of days backward to fetch transactions."`
-	Out               string `default:"jsonfile:out.json" help:"Where to write [jsonfile:/path/file.json es8:http://myelasticsearch:9200]"`
+	Port              int           `help:"Port to host HTTP server on (listens for Truelayer message)." default:8500`
+	Bind              string        `default:"localhost" help:"Address the callback server listens on (empty for all interfaces)."`
+	TLS               bool          `name:"tls" help:"Serve the callback over HTTPS, with a self signed certificate unless --tls-cert & --tls-key are given."`
+	TLSCert           string        `name:"tls-cert" help:"PEM certificate for the HTTPS callback server."`
+	TLSKey            string        `name:"tls-key" help:"PEM private key for the HTTPS callback server."`
+	Paste             bool          `help:"Run no callback server, instead paste in the URL the browser ends up at (for headless machines)."`
+	Redirect          string        `required help:"URL to have Truelayer send OAuth response to."`
+	TruelayerClientId string        `name:"client-id" help:"Truelayer client ID, or a secret reference (default from secret sources, as truelayer-client-id)."`
+	TruelayerSecret   string        `name:"secret" help:"Truelayer client secret reference, eg. pass:bank/truelayer (default from secret sources, as truelayer-secret)."`
+	StateTTL          time.Duration `name:"state-ttl" default:"10m" help:"How long the OAuth flow may take before it must be restarted."`
+	Timeout           time.Duration `default:"10m" help:"How long to wait for the OAuth callback before giving up."`
+	Days              int           `default:1095 help:"Number of days backward to fetch transactions."`
+	Name              string        `default:"truelayer" help:"Name to save the token under in the vault."`
+	Vault             string        `help:"Encrypted file to save the token in, so it can be used again (passphrase from secret sources, as passphrase, or asked for)."`
+	Archive           string        `help:"Directory to archive raw Truelayer replies in, so they can be reprocessed later. Replies are kept unencrypted."`
+	Out               []string      `default:"jsonfile:out.json" sep:"none" help:"Where to write, may be given more than once [jsonfile:/path/file.json?merge=true&encrypt=true ndjson:/path/file.ndjson csv:/path/file.csv parquet:/path/dir ledger:/path/file.journal sqlite:/path/file.db pg:postgres://host:5432/db es8:http://myelasticsearch:9200 opensearch:https://myopensearch:9200]"`
 }
 
 func main() {
 	ctx := kong.Parse(&cli)
-	err := ctx.Run(&cli.Ctx)
+
+	var err error
+	secrets, err = cli.Ctx.secretSource()
+	ctx.FatalIfErrorf(err)
+
+	err = ctx.Run(&cli.Ctx)
 	ctx.FatalIfErrorf(err)
 }
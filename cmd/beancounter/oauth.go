@@ -2,145 +2,299 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"github.com/voidshard/beancounter/pkg/archive"
 	"github.com/voidshard/beancounter/pkg/crypto"
 	"github.com/voidshard/beancounter/pkg/domain"
 	"github.com/voidshard/beancounter/pkg/provider"
-	"github.com/voidshard/beancounter/pkg/store"
+	"github.com/voidshard/beancounter/pkg/syncstate"
+	"github.com/voidshard/beancounter/pkg/vault"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
-	"strings"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// oauthState is what we send as the OAuth state parameter (encrypted &
+// signed) & expect to get back with the code.
 type oauthState struct {
-	Nonce         string `json:"nonce"`
-	keyEncryption string `json:"-"`
-	keySignature  string `json:"-"`
+	Nonce  string `json:"nonce"`
+	Issued int64  `json:"iat"`
 }
 
-func (s *oauthState) Verify(blob string) bool {
-	// we actually don't care about the value (we know it) only that
-	// the message is actually from Truelayer.
-	_, err := crypto.Decrypt(blob, s.keyEncryption, s.keySignature)
-	return err == nil
+// oauthPending is what we remember about a state we've issued
+type oauthPending struct {
+	Issued int64
+
+	// Verifier is the PKCE code_verifier for the flow, sent to the
+	// provider (as proof) when exchanging the code for a token
+	Verifier string
 }
 
-func (s *oauthState) Encrypt() (string, error) {
-	safenonce := base64.StdEncoding.EncodeToString([]byte(s.Nonce))
-	return crypto.Encrypt([]byte(safenonce), s.keyEncryption, s.keySignature)
+// oauthStates issues OAuth states & checks those that come back. States are
+// encrypted & signed with keys that only live as long as we do, are only
+// accepted once & expire after ttl.
+type oauthStates struct {
+	keyEncryption string
+	keySignature  string
+	ttl           time.Duration
+	now           func() time.Time
+
+	lock    sync.Mutex
+	pending map[string]*oauthPending // by nonce
 }
 
-func NewState() (*oauthState, error) {
+func newOAuthStates(ttl time.Duration) (*oauthStates, error) {
 	enckey, err := crypto.NewRandomKey()
 	if err != nil {
 		return nil, err
 	}
 
 	signkey, err := crypto.NewRandomKey()
+	if err != nil {
+		return nil, err
+	}
 
-	return &oauthState{
-		Nonce:         fmt.Sprintf("%d", time.Now().UnixNano()),
+	return &oauthStates{
 		keyEncryption: enckey,
 		keySignature:  signkey,
-	}, err
+		ttl:           ttl,
+		now:           time.Now,
+		pending:       map[string]*oauthPending{},
+	}, nil
 }
 
-func getStore(out string) (store.Store, error) {
-	bits := strings.SplitN(out, ":", 2)
-	if len(bits) != 2 {
-		return nil, fmt.Errorf("invalid out path, expected [jsonfile:/path/to/file.json] or [es8:http://elasticsearch:9200]")
+// New issues a state, returning it encrypted & ready to send along with
+// the PKCE (S256) code_challenge for the flow.
+func (s *oauthStates) New() (string, string, error) {
+	nonce, err := crypto.NewRandomKey()
+	if err != nil {
+		return "", "", err
+	}
+	state := &oauthState{Nonce: nonce, Issued: s.now().Unix()}
+
+	verifier, err := pkceVerifier()
+	if err != nil {
+		return "", "", err
 	}
 
-	if bits[0] == "es8" {
-		return store.NewElasticsearchV8(bits[1]), nil
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", "", err
+	}
+	blob, err := crypto.Encrypt(data, s.keyEncryption, s.keySignature)
+	if err != nil {
+		return "", "", err
 	}
 
-	return store.NewJSONFile(bits[1]), nil
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pending[state.Nonce] = &oauthPending{Issued: state.Issued, Verifier: verifier}
+
+	return blob, pkceChallenge(verifier), nil
 }
 
-func (l *truelayerCmd) Run(ctx *context) error {
-	u, err := url.Parse(l.Redirect)
+// Verify checks a state we've been sent is one we issued, that it hasn't
+// been used before & that it hasn't expired, returning the PKCE
+// code_verifier of the flow. Either way, it can't be used again.
+func (s *oauthStates) Verify(blob string) (string, error) {
+	data, err := crypto.Decrypt(blob, s.keyEncryption, s.keySignature)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to decrypt state & assert signature: %v", err)
 	}
-	u.Path = ""
 
-	storage, err := getStore(l.Out)
+	state := &oauthState{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return "", fmt.Errorf("invalid state: %v", err)
+	}
+
+	s.lock.Lock()
+	pending, ok := s.pending[state.Nonce]
+	delete(s.pending, state.Nonce)
+	s.lock.Unlock()
+
+	if !ok {
+		return "", fmt.Errorf("state is unknown or has already been used")
+	}
+	if pending.Issued != state.Issued {
+		return "", fmt.Errorf("state issued time doesn't match")
+	}
+
+	age := s.now().Sub(time.Unix(state.Issued, 0))
+	if age < 0 || age > s.ttl {
+		return "", fmt.Errorf("state has expired (issued %v ago, valid for %v)", age.Round(time.Second), s.ttl)
+	}
+
+	return pending.Verifier, nil
+}
+
+// pkceVerifier returns a random PKCE code_verifier (RFC 7636), 43 chars
+// from the unreserved set
+func pkceVerifier() (string, error) {
+	raw := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, raw)
+	return base64.RawURLEncoding.EncodeToString(raw), err
+}
+
+// pkceChallenge returns the S256 code_challenge for a code_verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (l *truelayerCmd) Run(ctx *context) error {
+	storage, err := getStores(l.Out)
 	if err != nil {
 		return err
 	}
 
-	state, err := NewState()
+	cfg, err := ctx.loadConfig(false)
 	if err != nil {
 		return err
 	}
 
-	// make oauth url
-	tl := provider.NewTruelayer(l.TruelayerClientId, l.TruelayerSecret)
-	cypher, err := state.Encrypt()
+	clientID, err := resolveSecret(l.TruelayerClientId, secretTruelayerClientID, "--client-id", false)
 	if err != nil {
 		return err
 	}
-	oauth, err := tl.OAuthURL(u.String(), cypher)
+	clientSecret, err := resolveSecret(l.TruelayerSecret, secretTruelayerSecret, "--secret", true)
 	if err != nil {
 		return err
 	}
 
-	// set up a listener
-	incoming := make(chan *domain.Token)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/favicon.ico" {
-			return // *sigh*
+	tl := provider.NewTruelayer(clientID, clientSecret)
+	if l.Archive != "" {
+		arc, err := archive.New(l.Archive)
+		if err != nil {
+			return err
 		}
+		tl.SetArchive(arc)
+	}
+
+	tkn, err := l.link(tl)
+	if err != nil {
+		return err
+	}
 
-		fmt.Println("recieved message at:", r.URL.String())
-		tkn, err := processCodeRequest(tl, l.Redirect, state, r)
+	if l.Vault != "" {
+		pass, err := passphrase()
 		if err != nil {
-			panic(fmt.Sprintf("failed to get token: %v", err))
+			return err
 		}
-		incoming <- tkn
-		w.WriteHeader(200)
-	})
-	go http.ListenAndServe(fmt.Sprintf(":%d", l.Port), nil)
-
-	// prompt user, block and wait for reply from truelayer
-	fmt.Println("Go to:", oauth)
-	tkn := <-incoming
-	if tkn == nil {
-		return nil
+		err = vault.New(l.Vault, pass).Put(l.Name, tkn)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Saved token to vault as", l.Name)
 	}
 
-	fmt.Println("Fetching transactions")
-	txns, err := tl.Transactions(tkn, time.Now().AddDate(0, 0, -1*l.Days), time.Now())
+	// kept with the sync state of the connection the token is named for
+	dir := syncstate.DefaultDir()
+	if cfg != nil {
+		dir = cfg.State
+	}
+	state, err := syncState(dir, l.Name, l.Vault != "")
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Writing to", l.Out)
-	return storage.Write(txns)
+	now := time.Now()
+	return fetchTransactions(state, tl, tkn, &syncstate.Window{
+		From:    now.AddDate(0, 0, -1*l.Days),
+		To:      now,
+		Chunk:   syncstate.DefaultChunk,
+		Overlap: syncstate.DefaultOverlap,
+	}, cfg, l.Name, storage, l.Out)
+}
+
+// link runs the OAuth flow, returning the token the provider gives us
+func (l *truelayerCmd) link(tl *provider.Truelayer) (*domain.Token, error) {
+	u, err := url.Parse(l.Redirect)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = ""
+
+	states, err := newOAuthStates(l.StateTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	// make oauth url
+	cypher, challenge, err := states.New()
+	if err != nil {
+		return nil, err
+	}
+	oauth, err := tl.OAuthURL(u.String(), cypher, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.Paste {
+		return l.serveCallback(tl, states, oauth)
+	}
+
+	// no server, the user hands us the URL they're redirected to
+	fmt.Println("Go to:", oauth)
+	query, err := readPastedCallback(os.Stdin, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	return exchangeCode(tl, l.Redirect, states, query)
 }
 
-func processCodeRequest(tl *provider.Truelayer, redirect string, state *oauthState, r *http.Request) (*domain.Token, error) {
-	// read out our code
-	qmap := r.URL.Query()
+// serveCallback runs a server for the provider to redirect the user to, over
+// HTTPS if configured, returning the token once we're sent a valid code.
+func (l *truelayerCmd) serveCallback(tl *provider.Truelayer, states *oauthStates, oauth string) (*domain.Token, error) {
+	srv := &http.Server{}
 
-	blob, ok := qmap["state"]
-	if !ok || len(blob) == 0 {
-		return nil, fmt.Errorf("state not returned")
+	if l.TLS || l.TLSCert != "" {
+		redirect, err := url.Parse(l.Redirect)
+		if err != nil {
+			return nil, err
+		}
+		cfg, fingerprint, err := callbackTLS(l.TLSCert, l.TLSKey, redirect.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up tls: %v", err)
+		}
+		srv.TLSConfig = cfg
+		if l.TLSCert == "" {
+			fmt.Println("Serving HTTPS with a self signed certificate, SHA-256 fingerprint:", fingerprint)
+		}
 	}
-	if !state.Verify(blob[0]) {
-		return nil, fmt.Errorf("failed to decrypt state & assert signature")
+
+	// listen before prompting, so we fail fast if the port is taken
+	ln, err := net.Listen("tcp", net.JoinHostPort(l.Bind, strconv.Itoa(l.Port)))
+	if err != nil {
+		return nil, err
 	}
 
-	// finally, we can get our code
-	code, ok := r.URL.Query()["code"]
-	if !ok || len(code) == 0 {
-		return nil, fmt.Errorf("code not returned")
+	handler := newCallbackHandler(states, func(code, verifier string) (*domain.Token, error) {
+		return tl.Token(l.Redirect, code, verifier)
+	})
+
+	// prompt user, block and wait for reply from truelayer
+	fmt.Println("Go to:", oauth)
+	return handler.serve(srv, ln, l.Timeout)
+}
+
+// exchangeCode checks the state of an OAuth callback & swaps its code for a
+// token
+func exchangeCode(tl *provider.Truelayer, redirect string, states *oauthStates, query url.Values) (*domain.Token, error) {
+	code, verifier, err := verifyCallback(states, query)
+	if err != nil {
+		return nil, err
 	}
 	fmt.Println("message verified, exchanging code for token with Truelayer")
 
-	// which we use to get a token ..
-	return tl.Token(redirect, code[0])
+	return tl.Token(redirect, code, verifier)
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/crypto"
+)
+
+func TestOAuthStateValid(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+
+	blob, _, err := states.New()
+	assert.Nil(t, err)
+	_, err = states.Verify(blob)
+	assert.Nil(t, err)
+}
+
+func TestOAuthStateTampered(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+
+	blob, _, err := states.New()
+	assert.Nil(t, err)
+
+	// flip a character of the cyphertext
+	bits := strings.SplitN(blob, ".", 2)
+	flipped := []byte(bits[0])
+	if flipped[5] == 'A' {
+		flipped[5] = 'B'
+	} else {
+		flipped[5] = 'A'
+	}
+	_, err = states.Verify(string(flipped) + "." + bits[1])
+	assert.NotNil(t, err)
+
+	// a state signed by someone else
+	key, _ := crypto.NewRandomKey()
+	sig, _ := crypto.NewRandomKey()
+	forged, err := crypto.Encrypt([]byte(`{"nonce":"x","iat":1}`), key, sig)
+	assert.Nil(t, err)
+	_, err = states.Verify(forged)
+	assert.NotNil(t, err)
+
+	_, err = states.Verify("not a state")
+	assert.NotNil(t, err)
+
+	// the genuine one is still good
+	_, err = states.Verify(blob)
+	assert.Nil(t, err)
+}
+
+func TestOAuthStateReplayed(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+
+	blob, _, err := states.New()
+	assert.Nil(t, err)
+
+	_, err = states.Verify(blob)
+	assert.Nil(t, err)
+	_, err = states.Verify(blob)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "already been used")
+}
+
+func TestOAuthStateStale(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	states.now = func() time.Time { return now }
+
+	stale, _, err := states.New()
+	assert.Nil(t, err)
+	fresh, _, err := states.New()
+	assert.Nil(t, err)
+
+	states.now = func() time.Time { return now.Add(2 * time.Minute) }
+	_, err = states.Verify(stale)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "expired")
+
+	// expired states can't be retried later either
+	states.now = func() time.Time { return now }
+	_, err = states.Verify(stale)
+	assert.NotNil(t, err)
+	_, err = states.Verify(fresh)
+	assert.Nil(t, err)
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// from RFC 7636 appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestOAuthStatePKCE(t *testing.T) {
+	states, err := newOAuthStates(time.Minute)
+	assert.Nil(t, err)
+
+	first, firstChallenge, err := states.New()
+	assert.Nil(t, err)
+	second, secondChallenge, err := states.New()
+	assert.Nil(t, err)
+	assert.NotEqual(t, firstChallenge, secondChallenge)
+
+	// each state gives back the verifier for its own challenge
+	verifier, err := states.Verify(second)
+	assert.Nil(t, err)
+	assert.Len(t, verifier, 43)
+	assert.Equal(t, secondChallenge, pkceChallenge(verifier))
+
+	verifier, err = states.Verify(first)
+	assert.Nil(t, err)
+	assert.Equal(t, firstChallenge, pkceChallenge(verifier))
+}
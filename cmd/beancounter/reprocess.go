@@ -0,0 +1,91 @@
+/*Reprocessing archived provider replies*/
+package main
+
+import (
+	"fmt"
+	"github.com/voidshard/beancounter/pkg/archive"
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/provider"
+)
+
+type reprocessCmd struct {
+	Archive string   `help:"Directory of archived provider replies (default the config's archive)."`
+	Bank    string   `help:"Only reprocess transactions of this bank."`
+	Account string   `help:"Only reprocess transactions of this account."`
+	Out     []string `default:"jsonfile:out.json" sep:"none" help:"Where to write, may be given more than once (as for link)."`
+}
+
+func (r *reprocessCmd) Run(ctx *context) error {
+	storage, err := getStores(r.Out)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := ctx.loadConfig(false)
+	if err != nil {
+		return err
+	}
+
+	dir := r.Archive
+	if dir == "" && cfg != nil {
+		dir = cfg.Archive
+	}
+	if dir == "" {
+		return fmt.Errorf("no archive given, set --archive")
+	}
+
+	arc, err := archive.New(dir)
+	if err != nil {
+		return err
+	}
+
+	records, err := arc.Records()
+	if err != nil {
+		return err
+	}
+
+	// replies are oldest first, so where fetches overlap the latest copy
+	// of a transaction wins
+	seen := map[[3]string]int{}
+	txns := []*domain.Transaction{}
+	replies := 0
+
+	for _, rec := range records {
+		if rec.Kind != archive.KindTransactions {
+			continue
+		}
+		if (r.Bank != "" && rec.Bank != r.Bank) || (r.Account != "" && rec.Account != r.Account) {
+			continue
+		}
+
+		body, err := arc.Get(rec.Hash)
+		if err != nil {
+			return err
+		}
+		found, err := provider.ParseArchived(rec, body)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s reply %s: %v", rec.Provider, rec.Hash, err)
+		}
+		replies++
+
+		for _, t := range found {
+			key := [3]string{t.Bank, t.Account, t.ID}
+			if i, ok := seen[key]; ok {
+				txns[i] = t
+				continue
+			}
+			seen[key] = len(txns)
+			txns = append(txns, t)
+		}
+	}
+
+	if cfg != nil {
+		cfg.Categorise(txns)
+	}
+
+	fmt.Printf("Parsed %d transactions from %d archived replies\n", len(txns), replies)
+	for _, out := range r.Out {
+		fmt.Println("Writing to", storeName(out))
+	}
+	return storage.Write(txns)
+}
@@ -0,0 +1,72 @@
+/*Secrets from env, files, the OS keyring or pass*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/voidshard/beancounter/pkg/secret"
+)
+
+const (
+	// names secrets are looked up by, in each source
+	secretTruelayerClientID = "truelayer-client-id"
+	secretTruelayerSecret   = "truelayer-secret"
+	secretPassphrase        = "passphrase"
+	secretNewPassphrase     = "new-passphrase"
+)
+
+// secrets are where we look for secrets not given on the command line, set
+// up from the global options before any command runs
+var secrets secret.Source = secret.NewEnv("")
+
+// secretSource returns the sources named by our options, in order
+func (c *context) secretSource() (secret.Source, error) {
+	chain := secret.Chain{}
+	for _, kind := range c.Secrets {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+		if kind == secret.KindFile && c.SecretsDir != "" {
+			chain = append(chain, secret.NewFile(c.SecretsDir))
+			continue
+		}
+		src, err := secret.NewSource(kind)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, src)
+	}
+	return chain, nil
+}
+
+// resolveSecret returns the value of a flag that may hold a secret. The flag
+// may hold a reference to the secret (eg. pass:bank/truelayer), or be left
+// out for the secret to be looked up by name in our sources. Sensitive values
+// given in plain text work, with a warning.
+func resolveSecret(value, name, flag string, sensitive bool) (string, error) {
+	if secret.IsReference(value) {
+		return secret.Resolve(value)
+	}
+	if value != "" {
+		if sensitive {
+			warnPlaintext(flag)
+		}
+		return value, nil
+	}
+
+	value, err := secrets.Get(name)
+	if errors.Is(err, secret.ErrNotFound) {
+		return "", fmt.Errorf("%s not given & %s %v", flag, name, err)
+	}
+	return value, err
+}
+
+// warnPlaintext warns that a secret was given on the command line
+func warnPlaintext(flag string) {
+	fmt.Fprintf(os.Stderr, "warning: %s was given in plain text, it's visible to other users (ps) & kept in your shell history. "+
+		"Give a reference (env:VAR, file:/path, keyring:name, pass:path) or leave it out to use a secret source\n", flag)
+}
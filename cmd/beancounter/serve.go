@@ -0,0 +1,101 @@
+/*Serving; syncing connections on schedules*/
+package main
+
+import (
+	gocontext "context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/scheduler"
+)
+
+// healthShutdown is how long we give health checks to finish when stopping
+const healthShutdown = 5 * time.Second
+
+type serveCmd struct {
+	Listen string `default:":8080" help:"Address to serve the health check (/health) on, empty for none."`
+	State  string `default:"serve-state.json" help:"File to keep how each connection's syncs went in, so runs missed while stopped happen on start."`
+}
+
+func (s *serveCmd) Run(ctx *context) error {
+	cfg, err := ctx.loadConfig(true)
+	if err != nil {
+		return err
+	}
+	if cfg.Vault != "" {
+		// there's nobody to ask once we're running, so fail now if there's
+		// no passphrase in the secret sources
+		_, err = passphrase()
+		if err != nil {
+			return err
+		}
+	}
+
+	sched, err := scheduler.New(s.State)
+	if err != nil {
+		return err
+	}
+	for _, name := range cfg.ConnectionNames() {
+		conn := cfg.Connections[name]
+		if conn.Schedule == "" {
+			fmt.Printf("%s has no schedule, it won't be synced\n", name)
+			continue
+		}
+		schedule, err := scheduler.Parse(conn.Schedule)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		name := name
+		sched.Add(&scheduler.Job{
+			Name:     name,
+			Schedule: schedule,
+			Jitter:   cfg.Jitter.Duration,
+			Run:      func() error { return syncConnection(cfg, name, false) },
+		})
+	}
+
+	status := sched.Status()
+	if len(status) == 0 {
+		return fmt.Errorf("no connections in %s have a schedule", cfg.Filename())
+	}
+	for _, st := range status {
+		fmt.Printf("%s next syncs at %s\n", st.Name, st.NextRun.Format(time.RFC3339))
+	}
+
+	run, stop := signal.NotifyContext(gocontext.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-run.Done()
+		fmt.Println("Stopping once any running sync finishes, interrupt again to stop now")
+		stop() // so that a second signal kills us
+	}()
+
+	if s.Listen != "" {
+		// listen first, so we fail fast if the port is taken
+		ln, err := net.Listen("tcp", s.Listen)
+		if err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/health", sched)
+		srv := &http.Server{Handler: mux}
+		go srv.Serve(ln)
+		defer func() {
+			shutdown, cancel := gocontext.WithTimeout(gocontext.Background(), healthShutdown)
+			defer cancel()
+			srv.Shutdown(shutdown)
+		}()
+		fmt.Println("Serving health check on", ln.Addr())
+	}
+
+	err = sched.Run(run)
+	fmt.Println("Stopped")
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/store"
+)
+
+func TestGetStoreAgeRecipients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	alice, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+	bob, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+	identity := filepath.Join(dir, "bob.txt")
+	assert.Nil(t, ioutil.WriteFile(identity, []byte(bob.String()+"\n"), 0600))
+
+	out := "ndjson:" + filepath.Join(dir, "out.ndjson") +
+		"?age=" + alice.Recipient().String() + "," + bob.Recipient().String() + "&age-identity=" + identity
+	s, err := getStore(out)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Write([]*domain.Transaction{&domain.Transaction{ID: "1", Timestamp: "2020-07-01T00:00:00Z"}}))
+
+	found, err := s.(store.Reader).Query(&store.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found))
+}
+
+func TestGetStoreOneCipher(t *testing.T) {
+	_, err := getStore("jsonfile:out.json?encrypt=true&pgp=key.asc")
+	assert.NotNil(t, err)
+
+	_, err = getStore("csv:out.csv?age=age1nope")
+	assert.NotNil(t, err)
+}
+
+func TestGetStoreReadBackNeedsIdentity(t *testing.T) {
+	alice, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+	recipient := "?age=" + alice.Recipient().String()
+
+	for _, out := range []string{
+		"jsonfile:out.json.age" + recipient + "&merge=true",
+		"ndjson:out.ndjson.age" + recipient + "&append=true",
+		"ledger:out.journal.age" + recipient,
+		"ledger:out.journal.gpg?pgp=alice.asc",
+	} {
+		_, err := getStore(out)
+		assert.NotNil(t, err, out)
+	}
+
+	// writing the whole file each time doesn't need to read it
+	for _, out := range []string{"jsonfile:out.json.age" + recipient, "ndjson:out.ndjson.age" + recipient} {
+		_, err := getStore(out)
+		assert.Nil(t, err, out)
+	}
+}
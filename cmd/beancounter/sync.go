@@ -0,0 +1,263 @@
+/*Syncing the connections of a config file*/
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/archive"
+	"github.com/voidshard/beancounter/pkg/config"
+	"github.com/voidshard/beancounter/pkg/crypto"
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/provider"
+	"github.com/voidshard/beancounter/pkg/store"
+	"github.com/voidshard/beancounter/pkg/syncstate"
+	"github.com/voidshard/beancounter/pkg/vault"
+)
+
+// tokens are renewed when they've less than this left, so that they don't
+// expire part way through a fetch
+const tokenRenewBefore = 5 * time.Minute
+
+type syncCmd struct {
+	Connections []string `arg optional help:"Connections to sync (default all those in the config file)."`
+}
+
+func (s *syncCmd) Run(ctx *context) error {
+	cfg, err := ctx.loadConfig(true)
+	if err != nil {
+		return err
+	}
+
+	names := s.Connections
+	if len(names) == 0 {
+		names = cfg.ConnectionNames()
+	}
+
+	// a connection failing shouldn't stop the others syncing
+	failed := []string{}
+	for _, name := range names {
+		fmt.Println("Syncing", name)
+		err := syncConnection(cfg, name, true)
+		if err != nil {
+			fmt.Printf("failed to sync %s: %v\n", name, err)
+			failed = append(failed, name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to sync %d of %d connections: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// syncConnection fetches transactions for a connection & writes them to its
+// stores. If we've no usable token for it, it's linked first if interactive
+// or else fails.
+func syncConnection(cfg *config.Config, name string, interactive bool) error {
+	conn, prov, err := cfg.Connection(name)
+	if err != nil {
+		return err
+	}
+
+	storage, err := getStores(cfg.Outs(conn))
+	if err != nil {
+		return err
+	}
+
+	tl, err := newProvider(cfg, conn.Provider, prov)
+	if err != nil {
+		return err
+	}
+
+	tkn, err := connectionToken(cfg, conn, tl)
+	if err != nil {
+		return err
+	}
+	if tkn == nil && !interactive {
+		return fmt.Errorf("no usable token for %s, link it again with: beancounter sync %s", name, name)
+	}
+	if tkn == nil {
+		fmt.Printf("No usable token for %s, linking\n", name)
+		tkn, err = linkOptions(prov).link(tl)
+		if err != nil {
+			return err
+		}
+		err = saveToken(cfg, conn, tkn)
+		if err != nil {
+			return err
+		}
+	}
+
+	state, err := syncState(cfg.State, name, cfg.Vault != "")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return fetchTransactions(state, tl, tkn, &syncstate.Window{
+		From:        now.AddDate(0, 0, -1*conn.Days),
+		To:          now,
+		Chunk:       syncstate.DefaultChunk,
+		Incremental: conn.Incremental,
+		Overlap:     syncstate.DefaultOverlap,
+	}, cfg, name, storage, cfg.Outs(conn))
+}
+
+// fetchTransactions fetches transactions through a sync state, categorises
+// them by the config's rules (if we've a config) & writes them out, along
+// with how far the connection has now synced (to stores that keep it).
+// Fetched chunks are kept as we go, so if we die (or an account fails)
+// before they're written the next fetch picks up where this one stopped.
+func fetchTransactions(state *syncstate.Connection, p provider.AccountProvider, tkn *domain.Token, w *syncstate.Window, cfg *config.Config, name string, storage store.Store, outs []string) error {
+	fmt.Println("Fetching transactions")
+	txns, fetchErr := state.Fetch(p, tkn, w)
+	if _, partial := fetchErr.(*syncstate.FetchError); fetchErr != nil && (!partial || len(txns) == 0) {
+		return fetchErr
+	}
+	if cfg != nil {
+		cfg.Categorise(txns)
+	}
+
+	for _, out := range outs {
+		fmt.Println("Writing to", storeName(out))
+	}
+	err := storage.Write(txns)
+	if err != nil {
+		return err
+	}
+
+	err = state.Complete()
+	if err != nil {
+		return err
+	}
+
+	if recorder, ok := storage.(store.SyncRecorder); ok {
+		err = recorder.RecordSync(accountSyncs(name, state))
+		if err != nil {
+			return fmt.Errorf("failed to record sync state: %v", err)
+		}
+	}
+	return fetchErr
+}
+
+// accountSyncs returns how far a connection has synced each account
+func accountSyncs(name string, state *syncstate.Connection) []*store.AccountSync {
+	syncs := []*store.AccountSync{}
+	for _, acc := range state.Accounts {
+		syncs = append(syncs, &store.AccountSync{
+			Connection: name,
+			Bank:       acc.Bank,
+			Account:    acc.Name,
+			SyncedFrom: acc.SyncedFrom,
+			SyncedTo:   acc.SyncedTo,
+			HighWater:  acc.HighWater,
+		})
+	}
+	return syncs
+}
+
+// syncState opens the sync state of a connection kept under dir. If sealed,
+// fetched transactions waiting to be written are sealed with our passphrase.
+func syncState(dir, name string, sealed bool) (*syncstate.Connection, error) {
+	var cipher store.Cipher
+	if sealed {
+		pass, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		cipher = crypto.Passphrase(pass)
+	}
+	return syncstate.Open(filepath.Join(dir, name), cipher)
+}
+
+// newProvider returns a provider set up as configured
+func newProvider(cfg *config.Config, name string, prov *config.Provider) (*provider.Truelayer, error) {
+	at := "providers." + name
+	clientID, err := resolveSecret(prov.ClientID, secretTruelayerClientID, at+".client_id", false)
+	if err != nil {
+		return nil, err
+	}
+	clientSecret, err := resolveSecret(prov.Secret, secretTruelayerSecret, at+".secret", true)
+	if err != nil {
+		return nil, err
+	}
+
+	tl := provider.NewTruelayer(clientID, clientSecret)
+	if cfg.Archive != "" {
+		arc, err := archive.New(cfg.Archive)
+		if err != nil {
+			return nil, err
+		}
+		tl.SetArchive(arc)
+	}
+	return tl, nil
+}
+
+// connectionToken returns the connection's token from the vault, renewing
+// it if it's (nearly) expired. If there's no vault, no token or it can't be
+// renewed we return nil.
+func connectionToken(cfg *config.Config, conn *config.Connection, tl *provider.Truelayer) (*domain.Token, error) {
+	if cfg.Vault == "" {
+		return nil, nil
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	tkn, err := vault.New(cfg.Vault, pass).Get(conn.Token)
+	if err == vault.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !tkn.ExpiresWithin(tokenRenewBefore) {
+		return tkn, nil
+	}
+	if tkn.Refresh == "" {
+		return nil, nil
+	}
+
+	fmt.Println("Renewing token", conn.Token)
+	fresh, err := tl.Refresh(tkn)
+	if err != nil {
+		// eg. the refresh token has expired too, so linking is all we can do
+		fmt.Printf("failed to renew token %s: %v\n", conn.Token, err)
+		return nil, nil
+	}
+	return fresh, saveToken(cfg, conn, fresh)
+}
+
+// saveToken keeps a connection's token in the vault, if there is one
+func saveToken(cfg *config.Config, conn *config.Connection, tkn *domain.Token) error {
+	if cfg.Vault == "" {
+		return nil
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return err
+	}
+	err = vault.New(cfg.Vault, pass).Put(conn.Token, tkn)
+	if err == nil {
+		fmt.Println("Saved token to vault as", conn.Token)
+	}
+	return err
+}
+
+// linkOptions returns the link command options given by a provider's config
+func linkOptions(prov *config.Provider) *truelayerCmd {
+	return &truelayerCmd{
+		Port:     prov.Port,
+		Bind:     *prov.Bind,
+		TLS:      prov.TLS,
+		TLSCert:  prov.TLSCert,
+		TLSKey:   prov.TLSKey,
+		Paste:    prov.Paste,
+		Redirect: prov.Redirect,
+		StateTTL: prov.StateTTL.Duration,
+		Timeout:  prov.Timeout.Duration,
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/provider"
+	"github.com/voidshard/beancounter/pkg/store"
+	"github.com/voidshard/beancounter/pkg/syncstate"
+)
+
+// accountsProvider has a transaction per account each day, & can be told to
+// fail for an account
+type accountsProvider struct {
+	failing map[string]bool
+}
+
+func (a *accountsProvider) Transactions(*domain.Token, time.Time, time.Time) ([]*domain.Transaction, error) {
+	return nil, fmt.Errorf("not used")
+}
+
+func (a *accountsProvider) Accounts(*domain.Token) ([]*provider.Account, error) {
+	return []*provider.Account{
+		{ID: "a1", Bank: "barclays", Name: "current"},
+		{ID: "a2", Bank: "barclays", Name: "savings"},
+	}, nil
+}
+
+func (a *accountsProvider) AccountTransactions(tkn *domain.Token, acc *provider.Account, from, to time.Time) ([]*domain.Transaction, error) {
+	if a.failing[acc.ID] {
+		return nil, fmt.Errorf("bank is down")
+	}
+	txns := []*domain.Transaction{}
+	for ts := from; !ts.After(to); ts = ts.Add(24 * time.Hour) {
+		txns = append(txns, &domain.Transaction{
+			ID:        acc.ID + ts.Format("2006-01-02"),
+			Bank:      acc.Bank,
+			Account:   acc.Name,
+			Timestamp: ts.Format(time.RFC3339),
+		})
+	}
+	return txns, nil
+}
+
+func TestFetchTransactionsResumeKeepsAccounts(t *testing.T) {
+	dir := t.TempDir()
+	out := "jsonfile:" + filepath.Join(dir, "out.json")
+	storage, err := getStore(out)
+	assert.Nil(t, err)
+
+	to := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	w := &syncstate.Window{From: to.AddDate(0, 0, -10), To: to}
+	p := &accountsProvider{failing: map[string]bool{"a2": true}}
+
+	// the second account fails, the first is written
+	state, err := syncState(dir, "test", false)
+	assert.Nil(t, err)
+	err = fetchTransactions(state, p, &domain.Token{}, w, nil, "test", storage, []string{out})
+	assert.IsType(t, &syncstate.FetchError{}, err)
+
+	// the retry writes both, as the file is replaced each time
+	p.failing["a2"] = false
+	state, err = syncState(dir, "test", false)
+	assert.Nil(t, err)
+	assert.Nil(t, fetchTransactions(state, p, &domain.Token{}, w, nil, "test", storage, []string{out}))
+
+	for _, account := range []string{"current", "savings"} {
+		found, err := storage.(store.Reader).Query(&store.Query{Account: account})
+		assert.Nil(t, err)
+		assert.Len(t, found, 11, account)
+	}
+}
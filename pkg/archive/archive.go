@@ -0,0 +1,198 @@
+/*Archive of raw provider responses*/
+package archive
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/voidshard/beancounter/pkg/fileutil"
+)
+
+const (
+	// KindAccounts is a reply listing accounts
+	KindAccounts = "accounts"
+
+	// KindTransactions is a reply listing the transactions of one account
+	KindTransactions = "transactions"
+
+	indexFilename = "index.ndjson"
+	objectDir     = "objects"
+	objectExt     = ".json.zst"
+)
+
+// Record describes one archived response; the request that was made & the
+// hash of the body we got back.
+type Record struct {
+	Hash     string `json:"hash"`
+	Provider string `json:"provider"`
+	Kind     string `json:"kind"`
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+
+	// Bank & Account are the names the response's transactions belong to
+	Bank      string `json:"bank,omitempty"`
+	Account   string `json:"account,omitempty"`
+	AccountID string `json:"account_id,omitempty"`
+
+	// From & To are the window (yyyy-mm-dd) that was asked for
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	Fetched time.Time `json:"fetched"`
+	Size    int       `json:"size"`
+}
+
+// Archive keeps raw provider responses on disk, so they can be parsed again
+// later without re-fetching. Bodies are zstd compressed & stored by their
+// sha256 (so the same reply is only stored once) and each fetch is recorded
+// in an index:
+//
+//	root/index.ndjson
+//	root/objects/ab/ab12...ef.json.zst
+type Archive struct {
+	root string
+	lock sync.Mutex
+}
+
+func New(root string) (*Archive, error) {
+	err := os.MkdirAll(filepath.Join(root, objectDir), 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{root: root}, nil
+}
+
+// Put archives a response body, filling in the record's hash & size
+func (a *Archive) Put(rec *Record, body []byte) error {
+	sum := sha256.Sum256(body)
+	rec.Hash = hex.EncodeToString(sum[:])
+	rec.Size = len(body)
+	if rec.Fetched.IsZero() {
+		rec.Fetched = time.Now().UTC()
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	err := a.putObject(rec.Hash, body)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(a.root, indexFilename), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// putObject writes a body under its hash, if we don't have it already
+func (a *Archive) putObject(hash string, body []byte) error {
+	filename := a.objectPath(hash)
+	if _, err := os.Stat(filename); err == nil {
+		return nil // content addressed, so it's the same data
+	}
+
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return err
+	}
+	data := enc.EncodeAll(body, nil)
+	enc.Close()
+
+	return fileutil.WriteAtomic(filename, data, 0600)
+}
+
+// Get returns the body of an archived response, checking it against its hash
+func (a *Archive) Get(hash string) ([]byte, error) {
+	data, err := ioutil.ReadFile(a.objectPath(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	body, err := dec.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %v", hash, err)
+	}
+
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("archived object %s is corrupt", hash)
+	}
+	return body, nil
+}
+
+// Records returns everything in the archive's index, oldest first
+func (a *Archive) Records() ([]*Record, error) {
+	f, err := os.Open(filepath.Join(a.root, indexFilename))
+	if os.IsNotExist(err) {
+		return []*Record{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []*Record{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec := &Record{}
+		err = json.Unmarshal(scanner.Bytes(), rec)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", indexFilename, line, err)
+		}
+		records = append(records, rec)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Fetched.Before(records[j].Fetched)
+	})
+	return records, nil
+}
+
+func (a *Archive) objectPath(hash string) string {
+	prefix := "xx"
+	if len(hash) >= 2 {
+		prefix = hash[:2]
+	}
+	return filepath.Join(a.root, objectDir, prefix, hash+objectExt)
+}
@@ -0,0 +1,60 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchivePutGet(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.Nil(t, err)
+
+	body := []byte(`{"results":[{"transaction_id":"1"}]}`)
+
+	first := &Record{Provider: "truelayer", Kind: KindTransactions, Bank: "b", Account: "a"}
+	assert.Nil(t, a.Put(first, body))
+	second := &Record{Provider: "truelayer", Kind: KindTransactions, Bank: "b", Account: "a"}
+	assert.Nil(t, a.Put(second, body))
+
+	// same content, same object
+	assert.Equal(t, first.Hash, second.Hash)
+	assert.Equal(t, len(body), first.Size)
+	objects, err := filepath.Glob(filepath.Join(a.root, objectDir, "*", "*"+objectExt))
+	assert.Nil(t, err)
+	assert.Len(t, objects, 1)
+
+	records, err := a.Records()
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "b", records[0].Bank)
+
+	got, err := a.Get(first.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, body, got)
+}
+
+func TestArchiveGetCorrupt(t *testing.T) {
+	a, err := New(t.TempDir())
+	assert.Nil(t, err)
+
+	rec := &Record{Kind: KindAccounts}
+	assert.Nil(t, a.Put(rec, []byte(`{"results":[]}`)))
+
+	other := &Record{Kind: KindAccounts}
+	assert.Nil(t, a.Put(other, []byte(`{"results":[{}]}`)))
+
+	// swap the contents of one object for another
+	data, err := ioutil.ReadFile(a.objectPath(other.Hash))
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(a.objectPath(rec.Hash), data, 0600))
+
+	_, err = a.Get(rec.Hash)
+	assert.NotNil(t, err)
+
+	_, err = a.Get("missing")
+	assert.True(t, os.IsNotExist(err))
+}
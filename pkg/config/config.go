@@ -0,0 +1,301 @@
+/*Declarative configuration; providers, connections, stores & rules*/
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/voidshard/beancounter/pkg/syncstate"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ProviderTruelayer = "truelayer"
+
+	// defaults for anything left out of a config file
+	defaultDays     = 1095
+	defaultPort     = 8500
+	defaultBind     = "localhost"
+	defaultStateTTL = 10 * time.Minute
+	defaultTimeout  = 10 * time.Minute
+)
+
+// names a config file is looked for by, in the config dir, in order
+var defaultNames = []string{"config.yaml", "config.yml", "config.toml"}
+
+// Config is read from a YAML or TOML file, eg.
+//
+//	vault: /home/me/.local/share/beancounter/tokens.vault
+//	providers:
+//	  truelayer:
+//	    type: truelayer
+//	    client_id: keyring:truelayer-client-id
+//	    secret: pass:bank/truelayer
+//	    redirect: https://localhost:8500
+//	    tls: true
+//	stores:
+//	  backup:
+//	    out: jsonfile:/data/bank.json?merge=true&encrypt=true
+//	  dashboards:
+//	    out: es8:http://localhost:9200
+//	connections:
+//	  barclays:
+//	    provider: truelayer
+//	    stores: [backup, dashboards]
+//	    days: 90
+//	    schedule: "0 6 * * *"
+//	    incremental: true
+//	rules:
+//	  - description: "(?i)tesco|sainsbury"
+//	    category: groceries
+//	    tags: [food]
+type Config struct {
+	// Vault is the encrypted file connection tokens are kept in, if any
+	Vault string `yaml:"vault" toml:"vault"`
+
+	// Archive is the directory raw provider replies are kept in, empty to
+	// keep none
+	Archive string `yaml:"archive" toml:"archive"`
+
+	// State is the directory the sync state of each connection is kept in,
+	// by default $XDG_STATE_HOME/beancounter
+	State string `yaml:"state" toml:"state"`
+
+	// Days is how far back to fetch, for connections that don't say
+	Days int `yaml:"days" toml:"days"`
+
+	// Schedule is when to sync connections that don't say, when serving;
+	// a cron spec (eg. "0 6 * * *") or descriptor (eg. "@every 6h")
+	Schedule string `yaml:"schedule" toml:"schedule"`
+
+	// Jitter is the most a scheduled sync may be put back by, a random
+	// amount each time
+	Jitter Duration `yaml:"jitter" toml:"jitter"`
+
+	Providers   map[string]*Provider   `yaml:"providers" toml:"providers"`
+	Stores      map[string]*Store      `yaml:"stores" toml:"stores"`
+	Connections map[string]*Connection `yaml:"connections" toml:"connections"`
+	Rules       []*Rule                `yaml:"rules" toml:"rules"`
+
+	filename string
+}
+
+// Provider is a data provider & the credentials & OAuth settings to use it
+type Provider struct {
+	// Type of provider, currently only truelayer
+	Type string `yaml:"type" toml:"type"`
+
+	// ClientID may be given as is or as a secret reference (eg.
+	// keyring:truelayer-client-id). Secret must be a reference. Either
+	// left out is looked up in the secret sources as usual.
+	ClientID string `yaml:"client_id" toml:"client_id"`
+	Secret   string `yaml:"secret" toml:"secret"`
+
+	// Redirect is the URL the provider sends the OAuth response to
+	Redirect string `yaml:"redirect" toml:"redirect"`
+
+	// the callback server, as for the link command flags
+	Port     int      `yaml:"port" toml:"port"`
+	Bind     *string  `yaml:"bind" toml:"bind"`
+	TLS      bool     `yaml:"tls" toml:"tls"`
+	TLSCert  string   `yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey   string   `yaml:"tls_key" toml:"tls_key"`
+	Paste    bool     `yaml:"paste" toml:"paste"`
+	StateTTL Duration `yaml:"state_ttl" toml:"state_ttl"`
+	Timeout  Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// Store is a named output, given as for --out (eg. sqlite:/path/file.db)
+type Store struct {
+	Out string `yaml:"out" toml:"out"`
+}
+
+// Connection is a link to a bank (or banks) through a provider, with the
+// stores its transactions are written to
+type Connection struct {
+	Provider string   `yaml:"provider" toml:"provider"`
+	Stores   []string `yaml:"stores" toml:"stores"`
+
+	// Token is the name of the connection's token in the vault, by default
+	// the connection name
+	Token string `yaml:"token" toml:"token"`
+
+	// Days is how far back to fetch transactions
+	Days int `yaml:"days" toml:"days"`
+
+	// Schedule is when to sync the connection when serving, if not given
+	// the config's schedule is used
+	Schedule string `yaml:"schedule" toml:"schedule"`
+
+	// Incremental syncs fetch each account from where the last sync got to
+	// (less a few days, for transactions the bank adds late) rather than
+	// Days back. Outputs that replace what they hold (eg. jsonfile without
+	// merge) can't be used with it.
+	Incremental bool `yaml:"incremental" toml:"incremental"`
+}
+
+// Duration is a time.Duration given as a string, eg. "10m"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = value
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// DefaultPath returns the config file to use when none is given; the first
+// of config.yaml, config.yml or config.toml that exists in
+// $XDG_CONFIG_HOME/beancounter, else config.yaml.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	dir = filepath.Join(dir, "beancounter")
+
+	for _, name := range defaultNames {
+		filename := filepath.Join(dir, name)
+		if _, err := os.Stat(filename); err == nil {
+			return filename
+		}
+	}
+	return filepath.Join(dir, defaultNames[0])
+}
+
+// Load reads & validates a config file, YAML unless it's named *.toml.
+// Problems with the contents are returned together as an *Error.
+func Load(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{filename: filename}
+	unknown := []string{}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", filename, err)
+		}
+		for _, key := range meta.Undecoded() {
+			unknown = append(unknown, fmt.Sprintf("%s: unknown setting", key))
+		}
+	default:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(cfg)
+		if err != nil && err != io.EOF { // an empty file is fine
+			return nil, fmt.Errorf("failed to parse %s: %v", filename, err)
+		}
+	}
+
+	cfg.setDefaults()
+
+	err = cfg.Validate()
+	if verr, ok := err.(*Error); ok {
+		verr.Problems = append(unknown, verr.Problems...)
+		return nil, verr
+	} else if err != nil {
+		return nil, err
+	}
+	if len(unknown) > 0 {
+		return nil, &Error{Filename: filename, Problems: unknown}
+	}
+	return cfg, nil
+}
+
+// Filename returns the file the config was read from
+func (c *Config) Filename() string {
+	return c.filename
+}
+
+// Connection returns the named connection & its provider
+func (c *Config) Connection(name string) (*Connection, *Provider, error) {
+	conn, ok := c.Connections[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("no connection %q in %s", name, c.filename)
+	}
+	return conn, c.Providers[conn.Provider], nil
+}
+
+// ConnectionNames returns the names of our connections, sorted
+func (c *Config) ConnectionNames() []string {
+	names := []string{}
+	for name := range c.Connections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Outs returns the outputs (as for --out) of a connection's stores
+func (c *Config) Outs(conn *Connection) []string {
+	outs := []string{}
+	for _, name := range conn.Stores {
+		outs = append(outs, c.Stores[name].Out)
+	}
+	return outs
+}
+
+// setDefaults fills in anything left out
+func (c *Config) setDefaults() {
+	if c.Days == 0 {
+		c.Days = defaultDays
+	}
+	if c.State == "" {
+		c.State = syncstate.DefaultDir()
+	}
+
+	for _, p := range c.Providers {
+		if p == nil {
+			continue
+		}
+		if p.Port == 0 {
+			p.Port = defaultPort
+		}
+		if p.Bind == nil {
+			bind := defaultBind
+			p.Bind = &bind
+		}
+		if p.StateTTL.Duration == 0 {
+			p.StateTTL.Duration = defaultStateTTL
+		}
+		if p.Timeout.Duration == 0 {
+			p.Timeout.Duration = defaultTimeout
+		}
+	}
+
+	for name, conn := range c.Connections {
+		if conn == nil {
+			continue
+		}
+		if conn.Token == "" {
+			conn.Token = name
+		}
+		if conn.Days == 0 {
+			conn.Days = c.Days
+		}
+		if conn.Schedule == "" {
+			conn.Schedule = c.Schedule
+		}
+	}
+}
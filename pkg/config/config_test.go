@@ -0,0 +1,191 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+const testYAML = `
+vault: tokens.vault
+providers:
+  truelayer:
+    type: truelayer
+    client_id: abc123
+    secret: pass:bank/truelayer
+    redirect: https://localhost:8500
+    tls: true
+stores:
+  backup:
+    out: jsonfile:/data/bank.json?merge=true
+  dashboards:
+    out: es8:http://localhost:9200
+connections:
+  barclays:
+    provider: truelayer
+    stores: [backup, dashboards]
+    days: 90
+    schedule: "@every 6h"
+  monzo:
+    provider: truelayer
+    stores: [backup]
+rules:
+  - description: "(?i)tesco|sainsbury"
+    category: groceries
+    tags: [food]
+  - description: "(?i)tesco"
+    max_amount: -100
+    tags: [big-shop]
+`
+
+const testTOML = `
+vault = "tokens.vault"
+
+[providers.truelayer]
+type = "truelayer"
+redirect = "https://localhost:8500"
+paste = true
+timeout = "5m"
+
+[stores.backup]
+out = "sqlite:/data/bank.db"
+
+[connections.barclays]
+provider = "truelayer"
+stores = ["backup"]
+`
+
+func writeConfig(t *testing.T, name, contents string) string {
+	filename := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(filename, []byte(contents), 0600)
+	assert.Nil(t, err)
+	return filename
+}
+
+func TestLoadYAML(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "config.yaml", testYAML))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"barclays", "monzo"}, cfg.ConnectionNames())
+
+	conn, prov, err := cfg.Connection("barclays")
+	assert.Nil(t, err)
+	assert.Equal(t, "barclays", conn.Token)
+	assert.Equal(t, 90, conn.Days)
+	assert.Equal(t, "@every 6h", conn.Schedule)
+	assert.Equal(t, "abc123", prov.ClientID)
+	assert.Equal(t, "localhost", *prov.Bind)
+	assert.Equal(t, 8500, prov.Port)
+	assert.Equal(t, []string{"jsonfile:/data/bank.json?merge=true", "es8:http://localhost:9200"}, cfg.Outs(conn))
+
+	conn, _, err = cfg.Connection("monzo")
+	assert.Nil(t, err)
+	assert.Equal(t, defaultDays, conn.Days)
+
+	_, _, err = cfg.Connection("nope")
+	assert.NotNil(t, err)
+}
+
+func TestLoadTOML(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "config.toml", testTOML))
+	assert.Nil(t, err)
+
+	_, prov, err := cfg.Connection("barclays")
+	assert.Nil(t, err)
+	assert.True(t, prov.Paste)
+	assert.Equal(t, "5m0s", prov.Timeout.String())
+	assert.Equal(t, defaultStateTTL, prov.StateTTL.Duration)
+}
+
+func TestLoadUnknownSettings(t *testing.T) {
+	_, err := Load(writeConfig(t, "config.yaml", testYAML+"colour: blue\n"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "colour")
+
+	_, err = Load(writeConfig(t, "config.toml", testTOML+"[connections.barclays.extra]\nx = 1\n"))
+	assert.IsType(t, &Error{}, err)
+	assert.Contains(t, err.Error(), "connections.barclays.extra: unknown setting")
+}
+
+func TestValidate(t *testing.T) {
+	_, err := Load(writeConfig(t, "config.yaml", `
+schedule: "61 * * * *"
+providers:
+  tl:
+    type: plaid
+    secret: hunter2
+    redirect: /callback
+    tls_cert: cert.pem
+stores:
+  broken:
+    out: mysql:/tmp/x
+  empty:
+    out: ""
+  latest:
+    out: jsonfile:/tmp/latest.json
+  log:
+    out: ndjson:/tmp/log.ndjson?append=true
+connections:
+  ../up:
+    provider: tl
+    stores: [broken]
+  barclays:
+    provider: truelayer
+    stores: [backup, broken]
+  monzo:
+    provider: tl
+  nationwide:
+    provider: tl
+    stores: [latest, log]
+    incremental: true
+rules:
+  - description: "(unclosed"
+    category: oops
+  - category: everything
+`))
+
+	verr, ok := err.(*Error)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		`schedule: end of range (61) above maximum (59): 61`,
+		`providers.tl.type: unknown provider type "plaid", expected truelayer`,
+		`providers.tl.secret: must be a secret reference (env:NAME, file:/path, keyring:name or pass:path), not the secret itself`,
+		`providers.tl.redirect: "/callback" is not an absolute URL`,
+		`providers.tl: tls_cert & tls_key must be given together`,
+		`stores.broken.out: "mysql:/tmp/x" should be given as kind:path, where kind is one of [jsonfile ndjson csv parquet ledger sqlite pg es8 opensearch]`,
+		`stores.empty.out: is required`,
+		`connections.../up: names may only hold letters, digits, '.', '_' & '-'`,
+		`connections.barclays.provider: unknown provider "truelayer", expected one of [tl]`,
+		`connections.barclays.stores: unknown store "backup", expected one of [broken empty latest log]`,
+		`connections.monzo.stores: at least one store is required`,
+		`connections.nationwide.incremental: store "latest" replaces its output each time, so it'd only hold the last few days (use jsonfile with merge=true or a database)`,
+		`connections.nationwide.incremental: store "log" appends without checking what it holds, so transactions in the week each sync overlaps the last would be added again`,
+		"rules[0].description: error parsing regexp: missing closing ): `(unclosed`",
+		`rules[1].match: rule has no conditions, it would match everything`,
+	}, verr.Problems)
+}
+
+func TestCategorise(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "config.yaml", testYAML))
+	assert.Nil(t, err)
+
+	txns := []*domain.Transaction{
+		{ID: "1", Description: "TESCO STORES 1234", Amount: -150, Tags: []string{"food"}},
+		{ID: "2", Description: "Sainsbury's", Amount: -20},
+		{ID: "3", Description: "Tesco", Amount: -5, Category: "PURCHASE"},
+		{ID: "4", Description: "Salary", Amount: 2000, Category: "CREDIT"},
+	}
+	cfg.Categorise(txns)
+
+	assert.Equal(t, "groceries", txns[0].Category)
+	assert.Equal(t, []string{"food", "big-shop"}, txns[0].Tags)
+	assert.Equal(t, "groceries", txns[1].Category)
+	assert.Equal(t, []string{"food"}, txns[1].Tags)
+	assert.Equal(t, "groceries", txns[2].Category)
+	assert.Equal(t, []string{"food"}, txns[2].Tags)
+	assert.Equal(t, "CREDIT", txns[3].Category)
+	assert.Nil(t, txns[3].Tags)
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+// Rule categorises & tags the transactions it matches. A transaction must
+// meet every condition given. Bank, Account & Type are matched exactly
+// (ignoring case), Description & Merchant are regular expressions.
+type Rule struct {
+	Bank        string   `yaml:"bank" toml:"bank"`
+	Account     string   `yaml:"account" toml:"account"`
+	Type        string   `yaml:"type" toml:"type"`
+	Description string   `yaml:"description" toml:"description"`
+	Merchant    string   `yaml:"merchant" toml:"merchant"`
+	MinAmount   *float64 `yaml:"min_amount" toml:"min_amount"`
+	MaxAmount   *float64 `yaml:"max_amount" toml:"max_amount"`
+
+	// Category replaces that of matching transactions, Tags are added to
+	// theirs
+	Category string   `yaml:"category" toml:"category"`
+	Tags     []string `yaml:"tags" toml:"tags"`
+
+	description *regexp.Regexp
+	merchant    *regexp.Regexp
+}
+
+// ruleError is a problem with one field of a rule
+type ruleError struct {
+	field string
+	err   error
+}
+
+// compile readies the rule's regular expressions, returning any problems
+func (r *Rule) compile() []*ruleError {
+	errs := []*ruleError{}
+
+	var err error
+	if r.Description != "" {
+		r.description, err = regexp.Compile(r.Description)
+		if err != nil {
+			errs = append(errs, &ruleError{"description", err})
+		}
+	}
+	if r.Merchant != "" {
+		r.merchant, err = regexp.Compile(r.Merchant)
+		if err != nil {
+			errs = append(errs, &ruleError{"merchant", err})
+		}
+	}
+
+	if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+		errs = append(errs, &ruleError{"min_amount", fmt.Errorf("%v is more than max_amount %v", *r.MinAmount, *r.MaxAmount)})
+	}
+	if r.Bank == "" && r.Account == "" && r.Type == "" && r.Description == "" && r.Merchant == "" && r.MinAmount == nil && r.MaxAmount == nil {
+		errs = append(errs, &ruleError{"match", fmt.Errorf("rule has no conditions, it would match everything")})
+	}
+	if r.Category == "" && len(r.Tags) == 0 {
+		errs = append(errs, &ruleError{"category", fmt.Errorf("rule sets neither a category nor tags")})
+	}
+
+	return errs
+}
+
+// Match returns if a transaction meets all of the rule's conditions
+func (r *Rule) Match(t *domain.Transaction) bool {
+	switch {
+	case r.Bank != "" && !strings.EqualFold(r.Bank, t.Bank):
+		return false
+	case r.Account != "" && !strings.EqualFold(r.Account, t.Account):
+		return false
+	case r.Type != "" && !strings.EqualFold(r.Type, t.Type):
+		return false
+	case r.description != nil && !r.description.MatchString(t.Description):
+		return false
+	case r.merchant != nil && !r.merchant.MatchString(t.Merchant):
+		return false
+	case r.MinAmount != nil && t.Amount < *r.MinAmount:
+		return false
+	case r.MaxAmount != nil && t.Amount > *r.MaxAmount:
+		return false
+	}
+	return true
+}
+
+// Apply sets the rule's category & adds its tags to a transaction
+func (r *Rule) Apply(t *domain.Transaction) {
+	if r.Category != "" {
+		t.Category = r.Category
+	}
+	for _, tag := range r.Tags {
+		if !hasTag(t, tag) {
+			t.Tags = append(t.Tags, tag)
+		}
+	}
+}
+
+// Categorise runs each rule, in order, over the transactions. Where more
+// than one rule matches, the last to set a category wins.
+func (c *Config) Categorise(txns []*domain.Transaction) {
+	for _, t := range txns {
+		for _, r := range c.Rules {
+			if r.Match(t) {
+				r.Apply(t)
+			}
+		}
+	}
+}
+
+func hasTag(t *domain.Transaction, tag string) bool {
+	for _, have := range t.Tags {
+		if have == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voidshard/beancounter/pkg/scheduler"
+	"github.com/voidshard/beancounter/pkg/secret"
+)
+
+// connection names are used as file names (eg. for sync state)
+var connectionName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// storeKinds are the outputs we know how to write to (see --out)
+var storeKinds = []string{
+	"jsonfile", "ndjson", "csv", "parquet", "ledger", "sqlite", "pg", "es8", "opensearch",
+}
+
+// Error lists every problem found in a config file, each prefixed with
+// where in the file it is (eg. connections.barclays.provider).
+type Error struct {
+	Filename string
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid config %s:\n\t%s", e.Filename, strings.Join(e.Problems, "\n\t"))
+}
+
+// Validate checks the config makes sense, returning an *Error listing
+// all the problems found.
+func (c *Config) Validate() error {
+	problems := []string{}
+	add := func(at, msg string, args ...interface{}) {
+		problems = append(problems, at+": "+fmt.Sprintf(msg, args...))
+	}
+
+	if c.Days < 0 {
+		add("days", "must be positive, not %d", c.Days)
+	}
+	if c.Schedule != "" {
+		_, err := scheduler.Parse(c.Schedule)
+		if err != nil {
+			add("schedule", "%v", err)
+		}
+	}
+	if c.Jitter.Duration < 0 {
+		add("jitter", "must be positive, not %v", c.Jitter)
+	}
+
+	for _, name := range c.providerNames() {
+		p := c.Providers[name]
+		at := "providers." + name
+		if p == nil {
+			add(at, "is empty")
+			continue
+		}
+		if p.Type != ProviderTruelayer {
+			add(at+".type", "unknown provider type %q, expected %s", p.Type, ProviderTruelayer)
+		}
+		if p.Secret != "" && !secret.IsReference(p.Secret) {
+			add(at+".secret", "must be a secret reference (env:NAME, file:/path, keyring:name or pass:path), not the secret itself")
+		}
+		if p.Redirect == "" {
+			add(at+".redirect", "is required")
+		} else if u, err := url.Parse(p.Redirect); err != nil || u.Scheme == "" || u.Host == "" {
+			add(at+".redirect", "%q is not an absolute URL", p.Redirect)
+		}
+		if p.Port < 1 || p.Port > 65535 {
+			add(at+".port", "%d is not a valid port", p.Port)
+		}
+		if (p.TLSCert == "") != (p.TLSKey == "") {
+			add(at, "tls_cert & tls_key must be given together")
+		}
+		if p.Paste && (p.TLS || p.TLSCert != "") {
+			add(at, "paste runs no callback server, so tls can't be used with it")
+		}
+	}
+
+	for _, name := range c.storeNames() {
+		s := c.Stores[name]
+		at := "stores." + name
+		if s == nil || s.Out == "" {
+			add(at+".out", "is required")
+			continue
+		}
+		err := validateOut(s.Out)
+		if err != nil {
+			add(at+".out", "%v", err)
+		}
+	}
+
+	if len(c.Connections) == 0 {
+		add("connections", "at least one connection is required")
+	}
+	for _, name := range c.ConnectionNames() {
+		conn := c.Connections[name]
+		at := "connections." + name
+		if conn == nil {
+			add(at, "is empty")
+			continue
+		}
+		if !connectionName.MatchString(name) {
+			add(at, "names may only hold letters, digits, '.', '_' & '-'")
+		}
+		if conn.Provider == "" {
+			add(at+".provider", "is required")
+		} else if _, ok := c.Providers[conn.Provider]; !ok {
+			add(at+".provider", "unknown provider %q, expected one of [%s]", conn.Provider, strings.Join(c.providerNames(), " "))
+		}
+		if len(conn.Stores) == 0 {
+			add(at+".stores", "at least one store is required")
+		}
+		seen := map[string]bool{}
+		for _, store := range conn.Stores {
+			if _, ok := c.Stores[store]; !ok {
+				add(at+".stores", "unknown store %q, expected one of [%s]", store, strings.Join(c.storeNames(), " "))
+			} else if seen[store] {
+				add(at+".stores", "store %q is given more than once", store)
+			}
+			seen[store] = true
+		}
+		if conn.Incremental {
+			for _, store := range conn.Stores {
+				s, ok := c.Stores[store]
+				if !ok || s == nil {
+					continue
+				}
+				if problem := incrementalProblem(s.Out); problem != "" {
+					add(at+".incremental", "store %q %s", store, problem)
+				}
+			}
+		}
+		if conn.Days < 0 {
+			add(at+".days", "must be positive, not %d", conn.Days)
+		}
+		if conn.Schedule != "" && conn.Schedule != c.Schedule {
+			_, err := scheduler.Parse(conn.Schedule)
+			if err != nil {
+				add(at+".schedule", "%v", err)
+			}
+		}
+	}
+
+	for i, r := range c.Rules {
+		at := fmt.Sprintf("rules[%d]", i)
+		if r == nil {
+			add(at, "is empty")
+			continue
+		}
+		for _, err := range r.compile() {
+			add(at+"."+err.field, "%v", err.err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &Error{Filename: c.filename, Problems: problems}
+	}
+	return nil
+}
+
+// validateOut checks an output looks like kind:path?options, without
+// opening anything
+func validateOut(out string) error {
+	bits := strings.SplitN(out, ":", 2)
+	known := false
+	for _, kind := range storeKinds {
+		known = known || bits[0] == kind
+	}
+	if len(bits) != 2 || !known {
+		return fmt.Errorf("%q should be given as kind:path, where kind is one of [%s]", out, strings.Join(storeKinds, " "))
+	}
+	if bits[1] == "" {
+		return fmt.Errorf("%s store has no path", bits[0])
+	}
+
+	opts := strings.SplitN(bits[1], "?", 2)
+	if len(opts) == 2 {
+		_, err := url.ParseQuery(opts[1])
+		if err != nil {
+			return fmt.Errorf("invalid options: %v", err)
+		}
+	}
+	return nil
+}
+
+// incrementalProblem returns why an output can't be synced incrementally,
+// if it can't. Incremental syncs only fetch the last few days, so outputs
+// that replace what they hold would lose the rest, & each overlaps the last
+// by a week, so outputs that add without checking IDs would repeat them.
+func incrementalProblem(out string) string {
+	replaces := "replaces its output each time, so it'd only hold the last few days (use jsonfile with merge=true or a database)"
+
+	bits := strings.SplitN(out, ":", 2)
+	opts := url.Values{}
+	if len(bits) == 2 {
+		parts := strings.SplitN(bits[1], "?", 2)
+		if len(parts) == 2 {
+			opts, _ = url.ParseQuery(parts[1])
+		}
+	}
+	enabled := func(name string) bool {
+		value, _ := strconv.ParseBool(opts.Get(name))
+		return value
+	}
+
+	switch bits[0] {
+	case "jsonfile":
+		if !enabled("merge") {
+			return replaces
+		}
+	case "ndjson":
+		if enabled("append") {
+			return "appends without checking what it holds, so transactions in the week each sync overlaps the last would be added again"
+		}
+		return replaces
+	case "csv":
+		return replaces
+	}
+	return ""
+}
+
+// providerNames returns the names of our providers, sorted so that
+// problems are reported in the same order each time
+func (c *Config) providerNames() []string {
+	names := []string{}
+	for name := range c.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// storeNames returns the names of our stores, sorted
+func (c *Config) storeNames() []string {
+	names := []string{}
+	for name := range c.Stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,198 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// sealedMagic starts everything sealed with a passphrase, so that we can
+	// tell it from plaintext
+	sealedMagic = "BEANSEAL"
+
+	// SealedVersion is the version of the header we write
+	SealedVersion = 1
+
+	// kdfArgon2id is the only key derivation we support, for now
+	kdfArgon2id = 1
+
+	saltSize = 16
+	keySize  = 32
+
+	// maxKDFMemory (KiB) bounds what a header can ask of us
+	maxKDFMemory = 4 * 1024 * 1024
+)
+
+// KDFParams are the Argon2id parameters a key is derived with. They're
+// stored in the header of each sealed file, so they can be raised later
+// without breaking old files.
+type KDFParams struct {
+	// Time is the number of passes over memory
+	Time uint32
+
+	// Memory is in KiB
+	Memory uint32
+
+	Threads uint8
+}
+
+// DefaultKDF is what we seal with, per the recommendations of RFC 9106 for
+// memory constrained environments.
+var DefaultKDF = KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// sealedHeader prefixes sealed data, it's authenticated along with the
+// cyphertext.
+//
+//	magic(8) version(1) kdf(1) time(4) memory(4) threads(1) salt(16) nonce(12)
+type sealedHeader struct {
+	Version uint8
+	KDF     uint8
+	Params  KDFParams
+	Salt    [saltSize]byte
+	Nonce   [12]byte
+}
+
+const sealedHeaderSize = len(sealedMagic) + 1 + 1 + 4 + 4 + 1 + saltSize + 12
+
+func (h *sealedHeader) bytes() []byte {
+	buf := bytes.NewBufferString(sealedMagic)
+	buf.WriteByte(h.Version)
+	buf.WriteByte(h.KDF)
+	binary.Write(buf, binary.BigEndian, h.Params.Time)
+	binary.Write(buf, binary.BigEndian, h.Params.Memory)
+	buf.WriteByte(h.Params.Threads)
+	buf.Write(h.Salt[:])
+	buf.Write(h.Nonce[:])
+	return buf.Bytes()
+}
+
+func readSealedHeader(data []byte) (*sealedHeader, error) {
+	if !IsSealed(data) {
+		return nil, fmt.Errorf("not sealed with a passphrase")
+	}
+	if len(data) < sealedHeaderSize {
+		return nil, fmt.Errorf("sealed data is truncated")
+	}
+
+	h := &sealedHeader{}
+	data = data[len(sealedMagic):]
+	h.Version, h.KDF = data[0], data[1]
+	if h.Version != SealedVersion {
+		return nil, fmt.Errorf("unsupported sealed version %d, expected %d", h.Version, SealedVersion)
+	}
+	if h.KDF != kdfArgon2id {
+		return nil, fmt.Errorf("unsupported key derivation %d", h.KDF)
+	}
+
+	h.Params.Time = binary.BigEndian.Uint32(data[2:6])
+	h.Params.Memory = binary.BigEndian.Uint32(data[6:10])
+	h.Params.Threads = data[10]
+	if h.Params.Time == 0 || h.Params.Threads == 0 || h.Params.Memory < 8*uint32(h.Params.Threads) || h.Params.Memory > maxKDFMemory {
+		return nil, fmt.Errorf("invalid key derivation parameters %+v", h.Params)
+	}
+	copy(h.Salt[:], data[11:11+saltSize])
+	copy(h.Nonce[:], data[11+saltSize:])
+
+	return h, nil
+}
+
+// IsSealed returns if data looks to have been sealed with a passphrase
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedMagic))
+}
+
+// Seal encrypts data with a key derived from passphrase (Argon2id, random
+// salt) using AES-256-GCM. The header recording how the key was derived is
+// authenticated too.
+func Seal(plaintext, passphrase []byte) ([]byte, error) {
+	return SealWithParams(plaintext, passphrase, DefaultKDF)
+}
+
+// SealWithParams is Seal with the given key derivation parameters
+func SealWithParams(plaintext, passphrase []byte, params KDFParams) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase required")
+	}
+
+	h := &sealedHeader{Version: SealedVersion, KDF: kdfArgon2id, Params: params}
+	_, err := io.ReadFull(rand.Reader, h.Salt[:])
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(rand.Reader, h.Nonce[:])
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := sealedCipher(h, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	header := h.bytes()
+	return gcm.Seal(header, h.Nonce[:], plaintext, header), nil
+}
+
+// Open is the inverse of Seal, failing if the passphrase is wrong or the
+// data has been tampered with.
+func Open(sealed, passphrase []byte) ([]byte, error) {
+	h, err := readSealedHeader(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := sealedCipher(h, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, h.Nonce[:], sealed[sealedHeaderSize:], sealed[:sealedHeaderSize])
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed, wrong passphrase or data corrupted")
+	}
+	return plaintext, nil
+}
+
+// Reseal opens data sealed with one passphrase & seals it with another (and
+// a new salt, with the current DefaultKDF).
+func Reseal(sealed, oldPassphrase, newPassphrase []byte) ([]byte, error) {
+	plaintext, err := Open(sealed, oldPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	return Seal(plaintext, newPassphrase)
+}
+
+// sealedCipher derives the key described by the header
+func sealedCipher(h *sealedHeader, passphrase []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(passphrase, h.Salt[:], h.Params.Time, h.Params.Memory, h.Params.Threads, keySize)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Passphrase seals & opens data with a passphrase, it can be handed to
+// anything that wants to encrypt what it stores.
+type Passphrase []byte
+
+func (p Passphrase) Seal(plaintext []byte) ([]byte, error) {
+	return Seal(plaintext, p)
+}
+
+func (p Passphrase) Open(sealed []byte) ([]byte, error) {
+	return Open(sealed, p)
+}
+
+func (p Passphrase) Sealed(data []byte) bool {
+	return IsSealed(data)
+}
@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cheap enough to not slow the tests down
+var testKDF = KDFParams{Time: 1, Memory: 64, Threads: 1}
+
+func TestSealOpen(t *testing.T) {
+	sealed, err := SealWithParams([]byte("hello"), []byte("correct horse"), testKDF)
+	assert.Nil(t, err)
+	assert.True(t, IsSealed(sealed))
+	assert.NotContains(t, string(sealed), "hello")
+
+	plain, err := Open(sealed, []byte("correct horse"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(plain))
+
+	_, err = Open(sealed, []byte("battery staple"))
+	assert.NotNil(t, err)
+
+	// fresh salt & nonce each time
+	again, err := SealWithParams([]byte("hello"), []byte("correct horse"), testKDF)
+	assert.Nil(t, err)
+	assert.NotEqual(t, sealed, again)
+}
+
+func TestSealRequiresPassphrase(t *testing.T) {
+	_, err := Seal([]byte("hello"), nil)
+	assert.NotNil(t, err)
+}
+
+func TestOpenTampered(t *testing.T) {
+	sealed, err := SealWithParams([]byte("hello"), []byte("pass"), testKDF)
+	assert.Nil(t, err)
+
+	// the header is authenticated too
+	for _, i := range []int{len(sealedMagic) + 5, len(sealedMagic) + 20, len(sealed) - 1} {
+		forged := append([]byte{}, sealed...)
+		forged[i] ^= 1
+		_, err = Open(forged, []byte("pass"))
+		assert.NotNil(t, err, i)
+	}
+
+	_, err = Open(sealed[:sealedHeaderSize-1], []byte("pass"))
+	assert.NotNil(t, err)
+	_, err = Open([]byte("hello"), []byte("pass"))
+	assert.NotNil(t, err)
+}
+
+func TestOpenUnknownVersion(t *testing.T) {
+	sealed, err := SealWithParams([]byte("hello"), []byte("pass"), testKDF)
+	assert.Nil(t, err)
+
+	sealed[len(sealedMagic)] = SealedVersion + 1
+	_, err = Open(sealed, []byte("pass"))
+	assert.Contains(t, err.Error(), "unsupported sealed version")
+}
+
+func TestReseal(t *testing.T) {
+	sealed, err := SealWithParams([]byte("hello"), []byte("old"), testKDF)
+	assert.Nil(t, err)
+
+	resealed, err := Reseal(sealed, []byte("old"), []byte("new"))
+	assert.Nil(t, err)
+
+	_, err = Open(resealed, []byte("old"))
+	assert.NotNil(t, err)
+	plain, err := Open(resealed, []byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(plain))
+
+	// rotating picks up the current parameters
+	h, err := readSealedHeader(resealed)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultKDF, h.Params)
+}
@@ -0,0 +1,226 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+const (
+	// ageMagic starts a (binary) age file, the armored form has its own
+	ageMagic = "age-encryption.org/"
+
+	pgpArmorHeader = "-----BEGIN PGP MESSAGE-----"
+
+	// the packets a message encrypted to a key (or passphrase) starts with
+	pgpPublicKeySession    = 1
+	pgpSymmetricKeySession = 3
+)
+
+// Age encrypts data to age X25519 recipients, so each of them can open it
+// with their own identity (private key).
+type Age struct {
+	recipients []age.Recipient
+	identities []age.Identity
+}
+
+// NewAge returns an age cipher sealing to the given recipients (age1...)
+// and opening with the identities in the given identity file contents
+// (AGE-SECRET-KEY-1...). Either may be empty if only sealing, or opening, is
+// needed.
+func NewAge(recipients []string, identities string) (*Age, error) {
+	a := &Age{}
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %v", r, err)
+		}
+		a.recipients = append(a.recipients, recipient)
+	}
+
+	if strings.TrimSpace(identities) != "" {
+		ids, err := age.ParseIdentities(strings.NewReader(identities))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identities: %v", err)
+		}
+		a.identities = ids
+	}
+
+	if len(a.recipients) == 0 && len(a.identities) == 0 {
+		return nil, fmt.Errorf("age requires at least one recipient or identity")
+	}
+	return a, nil
+}
+
+func (a *Age) Seal(plaintext []byte) ([]byte, error) {
+	if len(a.recipients) == 0 {
+		return nil, fmt.Errorf("no age recipients to encrypt to")
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := age.Encrypt(buf, a.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func (a *Age) Open(sealed []byte) ([]byte, error) {
+	if len(a.identities) == 0 {
+		return nil, fmt.Errorf("no age identity to decrypt with")
+	}
+
+	var r io.Reader = bytes.NewReader(sealed)
+	if bytes.HasPrefix(sealed, []byte(armor.Header)) {
+		r = armor.NewReader(r)
+	}
+
+	plain, err := age.Decrypt(r, a.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(plain)
+}
+
+func (a *Age) Sealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageMagic)) || bytes.HasPrefix(data, []byte(armor.Header))
+}
+
+// PGP encrypts data to OpenPGP public keys, so each of them can open it with
+// their own private key.
+type PGP struct {
+	to     openpgp.EntityList
+	keys   openpgp.EntityList
+	unlock func() ([]byte, error)
+}
+
+// NewPGP returns an OpenPGP cipher sealing to the given public keys &
+// opening with the given private keys (both armored or binary keyrings,
+// either may be empty). If a private key is protected by a passphrase,
+// unlock is called (if given) for it.
+func NewPGP(publicKeys []string, privateKeys []string, unlock func() ([]byte, error)) (*PGP, error) {
+	p := &PGP{unlock: unlock}
+
+	for _, key := range publicKeys {
+		entities, err := readKeyRing(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %v", err)
+		}
+		p.to = append(p.to, entities...)
+	}
+	for _, key := range privateKeys {
+		entities, err := readKeyRing(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %v", err)
+		}
+		p.keys = append(p.keys, entities...)
+	}
+
+	if len(p.to) == 0 && len(p.keys) == 0 {
+		return nil, fmt.Errorf("openpgp requires at least one public or private key")
+	}
+	return p, nil
+}
+
+func (p *PGP) Seal(plaintext []byte) ([]byte, error) {
+	if len(p.to) == 0 {
+		return nil, fmt.Errorf("no openpgp public keys to encrypt to")
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := openpgp.Encrypt(buf, p.to, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func (p *PGP) Open(sealed []byte) ([]byte, error) {
+	if len(p.keys) == 0 {
+		return nil, fmt.Errorf("no openpgp private key to decrypt with")
+	}
+
+	r, err := pgpReader(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	// asked for when the only matching keys are locked, more than once if
+	// unlocking doesn't help
+	prompted := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if prompted || p.unlock == nil || symmetric {
+			return nil, fmt.Errorf("openpgp private key is locked, a passphrase is required")
+		}
+		prompted = true
+
+		pass, err := p.unlock()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if k.PrivateKey != nil && k.PrivateKey.Encrypted {
+				k.PrivateKey.Decrypt(pass)
+			}
+		}
+		return nil, nil
+	}
+
+	md, err := openpgp.ReadMessage(r, p.keys, prompt, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(md.UnverifiedBody) // fails if the integrity check does
+}
+
+func (p *PGP) Sealed(data []byte) bool {
+	if bytes.HasPrefix(data, []byte(pgpArmorHeader)) {
+		return true
+	}
+	if len(data) == 0 || data[0]&0x80 == 0 {
+		return false
+	}
+
+	tag := (data[0] & 0x3c) >> 2
+	if data[0]&0x40 != 0 {
+		tag = data[0] & 0x3f // new packet format
+	}
+	return tag == pgpPublicKeySession || tag == pgpSymmetricKeySession
+}
+
+// pgpReader returns a reader over the binary message, whether armored or not
+func pgpReader(data []byte) (io.Reader, error) {
+	if !bytes.HasPrefix(data, []byte(pgpArmorHeader)) {
+		return bytes.NewReader(data), nil
+	}
+	block, err := pgparmor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return block.Body, nil
+}
+
+// readKeyRing reads armored or binary OpenPGP keys
+func readKeyRing(key string) (openpgp.EntityList, error) {
+	if strings.Contains(key, "-----BEGIN PGP") {
+		return openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	}
+	return openpgp.ReadKeyRing(strings.NewReader(key))
+}
@@ -0,0 +1,139 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAge(t *testing.T) {
+	alice, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+	bob, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+	eve, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+
+	sealer, err := NewAge([]string{alice.Recipient().String(), bob.Recipient().String()}, "")
+	assert.Nil(t, err)
+	sealed, err := sealer.Seal([]byte("hello"))
+	assert.Nil(t, err)
+	assert.True(t, sealer.Sealed(sealed))
+	assert.False(t, sealer.Sealed([]byte(`[{"id": "1"}]`)))
+
+	_, err = sealer.Open(sealed)
+	assert.NotNil(t, err) // no identity
+
+	// both recipients can read it, with their own keys
+	for _, id := range []*age.X25519Identity{alice, bob} {
+		opener, err := NewAge(nil, "# created: today\n"+id.String()+"\n")
+		assert.Nil(t, err)
+		plain, err := opener.Open(sealed)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(plain))
+	}
+
+	opener, err := NewAge(nil, eve.String())
+	assert.Nil(t, err)
+	_, err = opener.Open(sealed)
+	assert.NotNil(t, err)
+}
+
+func TestAgeInvalid(t *testing.T) {
+	_, err := NewAge([]string{"age1nope"}, "")
+	assert.NotNil(t, err)
+	_, err = NewAge(nil, "AGE-SECRET-KEY-1NOPE")
+	assert.NotNil(t, err)
+	_, err = NewAge(nil, "")
+	assert.NotNil(t, err)
+}
+
+// pgpKey returns a new key's armored public & private keys, the private key
+// locked with passphrase if given
+func pgpKey(t *testing.T, name string, passphrase []byte) (string, string) {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{RSABits: 2048})
+	assert.Nil(t, err)
+
+	public := &bytes.Buffer{}
+	w, err := armor.Encode(public, openpgp.PublicKeyType, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, entity.Serialize(w))
+	assert.Nil(t, w.Close())
+
+	if passphrase != nil {
+		assert.Nil(t, entity.EncryptPrivateKeys(passphrase, nil))
+	}
+	private := &bytes.Buffer{}
+	w, err = armor.Encode(private, openpgp.PrivateKeyType, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, entity.SerializePrivateWithoutSigning(w, nil))
+	assert.Nil(t, w.Close())
+
+	return public.String(), private.String()
+}
+
+func TestPGP(t *testing.T) {
+	alicePub, alicePriv := pgpKey(t, "alice", nil)
+	bobPub, bobPriv := pgpKey(t, "bob", []byte("bobs pass"))
+	_, evePriv := pgpKey(t, "eve", nil)
+
+	sealer, err := NewPGP([]string{alicePub, bobPub}, nil, nil)
+	assert.Nil(t, err)
+	sealed, err := sealer.Seal([]byte("hello"))
+	assert.Nil(t, err)
+	assert.True(t, sealer.Sealed(sealed))
+	assert.False(t, sealer.Sealed([]byte(`[{"id": "1"}]`)))
+	assert.False(t, sealer.Sealed([]byte("age-encryption.org/v1\n")))
+
+	opener, err := NewPGP(nil, []string{alicePriv}, nil)
+	assert.Nil(t, err)
+	plain, err := opener.Open(sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(plain))
+
+	// bob's key is locked
+	opener, err = NewPGP(nil, []string{bobPriv}, nil)
+	assert.Nil(t, err)
+	_, err = opener.Open(sealed)
+	assert.NotNil(t, err)
+
+	opener, err = NewPGP(nil, []string{bobPriv}, func() ([]byte, error) { return []byte("wrong"), nil })
+	assert.Nil(t, err)
+	_, err = opener.Open(sealed)
+	assert.NotNil(t, err)
+
+	opener, err = NewPGP(nil, []string{bobPriv}, func() ([]byte, error) { return []byte("bobs pass"), nil })
+	assert.Nil(t, err)
+	plain, err = opener.Open(sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(plain))
+
+	opener, err = NewPGP(nil, []string{evePriv}, nil)
+	assert.Nil(t, err)
+	_, err = opener.Open(sealed)
+	assert.NotNil(t, err)
+
+	opener, err = NewPGP(nil, []string{bobPriv}, func() ([]byte, error) { return nil, fmt.Errorf("no tty") })
+	assert.Nil(t, err)
+	_, err = opener.Open(sealed)
+	assert.NotNil(t, err)
+}
+
+func TestPGPTampered(t *testing.T) {
+	pub, priv := pgpKey(t, "alice", nil)
+	cipher, err := NewPGP([]string{pub}, []string{priv}, nil)
+	assert.Nil(t, err)
+
+	sealed, err := cipher.Seal([]byte("hello world, this is a longer message"))
+	assert.Nil(t, err)
+	sealed[len(sealed)-5] ^= 1
+
+	_, err = cipher.Open(sealed)
+	assert.NotNil(t, err)
+}
@@ -83,13 +83,17 @@ func Encrypt(plaintext []byte, key, sig string) (string, error) {
 	), nil
 }
 
-// toKey transforms a string of at least len 32 into *[32]byte, as needed by
+// toKey transforms a key from NewRandomKey into *[32]byte, as needed by
 // cryptopasta library.
 func toKey(s string) (*[32]byte, error) {
-	if len(s) < 32 {
-		return nil, fmt.Errorf("key too short for encryption/signing operation, want at least 32 chars.")
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key, expected one from NewRandomKey: %v", err)
+	}
+	if len(raw) < 32 {
+		return nil, fmt.Errorf("key too short for encryption/signing operation, want at least 32 bytes.")
 	}
 	data := &[32]byte{}
-	copy([]byte(s), data[:])
+	copy(data[:], raw)
 	return data, nil
 }
@@ -0,0 +1,47 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToKeyUsesKey(t *testing.T) {
+	key, err := NewRandomKey()
+	assert.Nil(t, err)
+
+	raw, err := toKey(key)
+	assert.Nil(t, err)
+	assert.NotEqual(t, [32]byte{}, *raw)
+
+	_, err = toKey("too short")
+	assert.NotNil(t, err)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, _ := NewRandomKey()
+	sig, _ := NewRandomKey()
+	otherKey, _ := NewRandomKey()
+	otherSig, _ := NewRandomKey()
+
+	blob, err := Encrypt([]byte("hello"), key, sig)
+	assert.Nil(t, err)
+
+	plain, err := Decrypt(blob, key, sig)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(plain))
+
+	// someone else's keys
+	_, err = Decrypt(blob, key, otherSig)
+	assert.NotNil(t, err)
+	_, err = Decrypt(blob, otherKey, sig)
+	assert.NotNil(t, err)
+
+	// tampered with
+	bits := strings.SplitN(blob, ".", 2)
+	forged, err := Encrypt([]byte("hello"), otherKey, otherSig)
+	assert.Nil(t, err)
+	_, err = Decrypt(strings.SplitN(forged, ".", 2)[0]+"."+bits[1], key, sig)
+	assert.NotNil(t, err)
+}
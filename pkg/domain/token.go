@@ -28,3 +28,8 @@ func NewToken(value, refresh string, ttl int) *Token {
 func (t *Token) HasExpired() bool {
 	return time.Now().UTC().Unix() >= t.Expires
 }
+
+// ExpiresWithin returns if the token will have expired in d from now
+func (t *Token) ExpiresWithin(d time.Duration) bool {
+	return time.Now().UTC().Add(d).Unix() >= t.Expires
+}
@@ -2,8 +2,17 @@ package domain
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
 )
 
+// timestampLayouts are the formats we've seen providers hand back
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
 type Transaction struct {
 	ID string `json:"id"`
 
@@ -19,8 +28,34 @@ type Transaction struct {
 	Merchant    string  `json:"merchant"`
 
 	Tags []string `json:"tags"`
+
+	// RunningBalance is the account balance after the transaction, if the
+	// provider gave one
+	RunningBalance *float64 `json:"running_balance,omitempty"`
+
+	Counterparty string `json:"counterparty,omitempty"`
+	Reference    string `json:"reference,omitempty"`
+
+	// ProviderID & NormalisedProviderID are the bank's own IDs for the
+	// transaction, as passed on by the provider
+	ProviderID           string `json:"provider_id,omitempty"`
+	NormalisedProviderID string `json:"normalised_provider_id,omitempty"`
+
+	// Extra holds any other provider specific details, eg. card_number
+	Extra map[string]string `json:"extra,omitempty"`
 }
 
 func (t *Transaction) JSON() ([]byte, error) {
 	return json.Marshal(t)
 }
+
+// Time parses Timestamp into a time.Time
+func (t *Transaction) Time() (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		ts, err := time.Parse(layout, t.Timestamp)
+		if err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("transaction %s has unrecognised timestamp %q", t.ID, t.Timestamp)
+}
@@ -0,0 +1,46 @@
+/*File helpers shared by our stores, vault & state*/
+package fileutil
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteAtomic writes data to a temp file next to filename, syncs it & then
+// renames it into place, so readers never see a half written file.
+func WriteAtomic(filename string, data []byte, perm os.FileMode) error {
+	return StreamAtomic(filename, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// StreamAtomic is WriteAtomic for when we'd rather stream the data.
+func StreamAtomic(filename string, perm os.FileMode, write func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,53 @@
+package fileutil
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "out.json")
+
+	assert.Nil(t, WriteAtomic(filename, []byte("one"), 0644))
+	assert.Nil(t, WriteAtomic(filename, []byte("two"), 0600))
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "two", string(data))
+
+	info, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// no temp files are left behind
+	entries, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestStreamAtomicFailure(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "out.json")
+	assert.Nil(t, WriteAtomic(filename, []byte("kept"), 0644))
+
+	err := StreamAtomic(filename, 0644, func(w io.Writer) error {
+		w.Write([]byte("half"))
+		return fmt.Errorf("failed")
+	})
+	assert.NotNil(t, err)
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "kept", string(data))
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+}
@@ -0,0 +1,22 @@
+package provider
+
+import (
+	"fmt"
+	"github.com/voidshard/beancounter/pkg/archive"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+// ParseArchived parses an archived reply into transactions, using the
+// current parsing code. Replies that don't hold transactions give none.
+func ParseArchived(rec *archive.Record, body []byte) ([]*domain.Transaction, error) {
+	if rec.Kind != archive.KindTransactions {
+		return nil, nil
+	}
+
+	switch rec.Provider {
+	case TruelayerName:
+		return parseTruelayerTransactions(rec.Bank, rec.Account, body)
+	}
+
+	return nil, fmt.Errorf("unknown provider %q in archive", rec.Provider)
+}
@@ -8,3 +8,22 @@ import (
 type Provider interface {
 	Transactions(*domain.Token, time.Time, time.Time) ([]*domain.Transaction, error)
 }
+
+// Account is a bank account a provider gives us access to
+type Account struct {
+	// ID is the provider's ID for the account
+	ID string `json:"id"`
+
+	// Bank & Name are as set on the account's transactions
+	Bank string `json:"bank"`
+	Name string `json:"name"`
+}
+
+// AccountProvider can fetch transactions an account at a time, so that a
+// fetch can be broken up (& resumed if it's interrupted)
+type AccountProvider interface {
+	Provider
+
+	Accounts(*domain.Token) ([]*Account, error)
+	AccountTransactions(*domain.Token, *Account, time.Time, time.Time) ([]*domain.Transaction, error)
+}
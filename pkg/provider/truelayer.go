@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/voidshard/beancounter/pkg/archive"
 	"github.com/voidshard/beancounter/pkg/domain"
 	"io"
 	"io/ioutil"
@@ -18,10 +19,13 @@ import (
 
 const (
 	retries = 5
+
+	// TruelayerName identifies Truelayer, eg. in archived responses
+	TruelayerName = "truelayer"
 )
 
-// check it meets the interface
-var _ Provider = &Truelayer{}
+// check it meets the interfaces
+var _ AccountProvider = &Truelayer{}
 
 func NewTruelayer(clientId, clientSecret string) *Truelayer {
 	return &Truelayer{
@@ -33,9 +37,17 @@ func NewTruelayer(clientId, clientSecret string) *Truelayer {
 type Truelayer struct {
 	clientId     string
 	clientSecret string
+	archive      *archive.Archive
+}
+
+// SetArchive sets where raw replies are archived (nil to not archive)
+func (t *Truelayer) SetArchive(a *archive.Archive) {
+	t.archive = a
 }
 
-func (t *Truelayer) OAuthURL(redirect, state string) (string, error) {
+// OAuthURL returns the link to start an OAuth flow. If a PKCE (S256)
+// challenge is given the matching verifier must be passed to Token.
+func (t *Truelayer) OAuthURL(redirect, state, challenge string) (string, error) {
 	u := &url.URL{Scheme: "https", Host: "auth.truelayer.com"}
 
 	u.Path = "/"
@@ -46,13 +58,17 @@ func (t *Truelayer) OAuthURL(redirect, state string) (string, error) {
 	params.Add("redirect_uri", redirect)
 	params.Add("state", state)
 	params.Add("providers", "uk-oauth-all uk-ob-all")
+	if challenge != "" {
+		params.Add("code_challenge", challenge)
+		params.Add("code_challenge_method", "S256")
+	}
 
 	// request permission to:
 	// get accounts
 	// get transactions
 	// get balance info for accounts (and with transactions)
 	// refresh tokens offline
-	params.Add("scope", "balance transactions accounts")
+	params.Add("scope", "balance transactions accounts offline_access")
 
 	u.RawQuery = params.Encode() // escape all the things
 
@@ -60,50 +76,11 @@ func (t *Truelayer) OAuthURL(redirect, state string) (string, error) {
 }
 
 func (t *Truelayer) Transactions(token *domain.Token, from, to time.Time) ([]*domain.Transaction, error) {
-	params := url.Values{}
-	params.Add("async", "true")
-
-	u := &url.URL{Scheme: "https", Host: "api.truelayer.com"}
-	u.Path = "/data/v1/accounts"
-	u.RawQuery = params.Encode()
-
-	result, err := doGet(u.String(), token.Value)
-	if err != nil {
-		return nil, err
-	}
-
-	async, err := parseTruelayerAsync(result)
-	if err != nil {
-		return nil, err
-	}
-
-	return t.pollAccounts(token, from, to, async.ResultsURI)
-}
-
-func date(t time.Time) string {
-	year, month, day := t.Date()
-	return fmt.Sprintf("%d-%02d-%02d", year, month, day)
-}
-
-func (t *Truelayer) pollAccounts(token *domain.Token, from, to time.Time, poll string) ([]*domain.Transaction, error) {
-	sleep(time.Second*120, "giving Truelayer time to fetch accounts")
-
-	result, err := doGet(poll, token.Value)
+	accounts, err := t.Accounts(token)
 	if err != nil {
 		return nil, err
 	}
 
-	accounts, err := parseTruelayerAccounts(result)
-	if err != nil {
-		return nil, err
-	}
-
-	params := url.Values{}
-	params.Add("async", "true")
-	params.Add("from", date(from))
-	params.Add("to", date(to))
-	paramQuery := params.Encode() // it's the same for all accounts, no sense redoing this
-
 	wg := &sync.WaitGroup{}
 
 	rChan := make(chan []*domain.Transaction)
@@ -111,8 +88,7 @@ func (t *Truelayer) pollAccounts(token *domain.Token, from, to time.Time, poll s
 	finalChan := make(chan []*domain.Transaction)
 
 	go func() { // error printer
-		err := <-eChan
-		if err != nil {
+		for err := range eChan {
 			log.Printf("err fetching transactions: %v\n", err)
 		}
 	}()
@@ -126,30 +102,13 @@ func (t *Truelayer) pollAccounts(token *domain.Token, from, to time.Time, poll s
 		finalChan <- txns
 	}()
 
-	for _, account := range accounts.Results {
+	for _, account := range accounts {
 		wg.Add(1)
 
 		go func() { // fan out
 			defer wg.Done()
-			acc := account // closure
-
-			u := &url.URL{Scheme: "https", Host: "api.truelayer.com"}
-			u.Path = fmt.Sprintf("/data/v1/accounts/%s/transactions", acc.ID)
-			u.RawQuery = paramQuery
-
-			result, err := doGet(u.String(), token.Value)
-			if err != nil {
-				eChan <- err
-				return
-			}
 
-			async, err := parseTruelayerAsync(result)
-			if err != nil {
-				eChan <- err
-				return
-			}
-
-			tx, err := t.pollTransactions(token, async.ResultsURI, acc.Provider.Name, acc.Name)
+			tx, err := t.AccountTransactions(token, account, from, to)
 			if err != nil {
 				eChan <- err
 				return
@@ -168,13 +127,110 @@ func (t *Truelayer) pollAccounts(token *domain.Token, from, to time.Time, poll s
 	return <-finalChan, nil
 }
 
-func (t *Truelayer) pollTransactions(token *domain.Token, poll, bank, acc string) ([]*domain.Transaction, error) {
+func date(t time.Time) string {
+	year, month, day := t.Date()
+	return fmt.Sprintf("%d-%02d-%02d", year, month, day)
+}
+
+// Accounts returns the accounts the token gives us access to
+func (t *Truelayer) Accounts(token *domain.Token) ([]*Account, error) {
+	params := url.Values{}
+	params.Add("async", "true")
+
+	u := &url.URL{Scheme: "https", Host: "api.truelayer.com"}
+	u.Path = "/data/v1/accounts"
+	u.RawQuery = params.Encode()
+
+	result, err := doGet(u.String(), token.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	async, err := parseTruelayerAsync(result)
+	if err != nil {
+		return nil, err
+	}
+
+	sleep(time.Second*120, "giving Truelayer time to fetch accounts")
+
+	result, err = doGet(async.ResultsURI, token.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	t.archiveReply(&archive.Record{Kind: archive.KindAccounts, URL: async.ResultsURI}, result)
+
+	reply, err := parseTruelayerAccounts(result)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []*Account{}
+	for _, acc := range reply.Results {
+		accounts = append(accounts, &Account{ID: acc.ID, Bank: acc.Provider.Name, Name: acc.Name})
+	}
+	return accounts, nil
+}
+
+// AccountTransactions returns the transactions of one account between from
+// & to
+func (t *Truelayer) AccountTransactions(token *domain.Token, acc *Account, from, to time.Time) ([]*domain.Transaction, error) {
+	params := url.Values{}
+	params.Add("async", "true")
+	params.Add("from", date(from))
+	params.Add("to", date(to))
+
+	u := &url.URL{Scheme: "https", Host: "api.truelayer.com"}
+	u.Path = fmt.Sprintf("/data/v1/accounts/%s/transactions", acc.ID)
+	u.RawQuery = params.Encode()
+
+	result, err := doGet(u.String(), token.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	async, err := parseTruelayerAsync(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.pollTransactions(token, async.ResultsURI, &archive.Record{
+		Kind:      archive.KindTransactions,
+		Bank:      acc.Bank,
+		Account:   acc.Name,
+		AccountID: acc.ID,
+		From:      date(from),
+		To:        date(to),
+	})
+}
+
+func (t *Truelayer) pollTransactions(token *domain.Token, poll string, rec *archive.Record) ([]*domain.Transaction, error) {
 	sleep(time.Second*120, "giving Truelayer time to fetch transactions")
 	result, err := doGet(poll, token.Value)
 	if err != nil {
 		return nil, err
 	}
-	return parseTruelayerTransactions(bank, acc, result)
+
+	rec.URL = poll
+	t.archiveReply(rec, result)
+
+	return parseTruelayerTransactions(rec.Bank, rec.Account, result)
+}
+
+// archiveReply keeps a raw reply, if we've been given an archive. Failing
+// to archive shouldn't cost us the fetch, so errors are only logged.
+func (t *Truelayer) archiveReply(rec *archive.Record, body []byte) {
+	if t.archive == nil {
+		return
+	}
+
+	rec.Provider = TruelayerName
+	rec.Method = "GET"
+
+	err := t.archive.Put(rec, body)
+	if err != nil {
+		log.Printf("failed to archive %s reply: %v\n", rec.Kind, err)
+	}
 }
 
 func sleep(t time.Duration, msg string) {
@@ -182,7 +238,9 @@ func sleep(t time.Duration, msg string) {
 	time.Sleep(t)
 }
 
-func (t *Truelayer) Token(redirect, code string) (*domain.Token, error) {
+// Token exchanges an OAuth code for a token, proving (with the PKCE
+// verifier, if any) that we're who started the flow.
+func (t *Truelayer) Token(redirect, code, verifier string) (*domain.Token, error) {
 	// Expect reply like:
 	//   {
 	//      "access_token": "JWT-ACCESS-TOKEN-HERE",
@@ -195,13 +253,18 @@ func (t *Truelayer) Token(redirect, code string) (*domain.Token, error) {
 	u := &url.URL{Scheme: "https", Host: "auth.truelayer.com"}
 	u.Path = "/connect/token"
 
-	data, err := json.Marshal(map[string]string{
+	body := map[string]string{
 		"grant_type":    "authorization_code",
 		"client_id":     t.clientId,
 		"client_secret": t.clientSecret,
 		"redirect_uri":  redirect,
 		"code":          code,
-	})
+	}
+	if verifier != "" {
+		body["code_verifier"] = verifier
+	}
+
+	data, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
@@ -220,22 +283,64 @@ func (t *Truelayer) Token(redirect, code string) (*domain.Token, error) {
 	return domain.NewToken(tok.AccessToken, tok.RefreshToken, tok.ExpiresIn), nil
 }
 
+// Refresh swaps a token's refresh token for a new token. If we aren't given
+// a new refresh token, the old one is kept.
+func (t *Truelayer) Refresh(tkn *domain.Token) (*domain.Token, error) {
+	if tkn.Refresh == "" {
+		return nil, fmt.Errorf("token has no refresh token")
+	}
+
+	u := &url.URL{Scheme: "https", Host: "auth.truelayer.com"}
+	u.Path = "/connect/token"
+
+	data, err := json.Marshal(map[string]string{
+		"grant_type":    "refresh_token",
+		"client_id":     t.clientId,
+		"client_secret": t.clientSecret,
+		"refresh_token": tkn.Refresh,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doPost(u.String(), data)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh, err := ParseTruelayerToken(resp)
+	if err != nil {
+		return nil, err
+	}
+	if fresh.Refresh == "" {
+		fresh.Refresh = tkn.Refresh
+	}
+	return fresh, nil
+}
+
 func doGet(uri, token string) ([]byte, error) {
 	return doRequest("GET", token, uri, nil)
 }
 
 func doPost(uri string, data []byte) ([]byte, error) {
-	return doRequest("POST", "", uri, bytes.NewBuffer(data))
+	return doRequest("POST", "", uri, data)
 }
 
-func doRequest(method, token, uri string, data io.Reader) ([]byte, error) {
+func doRequest(method, token, uri string, data []byte) ([]byte, error) {
 	var last error
 	client := &http.Client{}
 
 	for i := retries; i > 0; i-- {
 		fmt.Println(method, uri)
 
-		req, err := http.NewRequest(method, uri, data)
+		// a reader is used up by the attempt that sends it, so each
+		// retry needs a new one
+		var reader io.Reader
+		if data != nil {
+			reader = bytes.NewReader(data)
+		}
+
+		req, err := http.NewRequest(method, uri, reader)
 		if err != nil {
 			return nil, err
 		}
@@ -3,8 +3,20 @@ package provider
 import (
 	"encoding/json"
 	"github.com/voidshard/beancounter/pkg/domain"
+	"strconv"
 )
 
+const (
+	// meta fields we map onto transactions, the rest are kept as extras
+	tlMetaCounterparty = "counter_party_preferred_name"
+	tlMetaReference    = "provider_reference"
+)
+
+// tlMetaRenames normalises meta keys that aren't snake case like the rest
+var tlMetaRenames = map[string]string{
+	"cardNumber": "card_number",
+}
+
 type token struct {
 	AccessToken  string `json:"access_token"`
 	ExpiresIn    int    `json:"expires_in"`
@@ -74,6 +86,16 @@ type truelayerTransaction struct {
 	Category       string   `json:"transaction_category"`
 	Classification []string `json:"transaction_classification"`
 	Merchant       string   `json:"merchant_name"`
+
+	RunningBalance       *truelayerAmount       `json:"running_balance"`
+	Meta                 map[string]interface{} `json:"meta"`
+	ProviderID           string                 `json:"provider_transaction_id"`
+	NormalisedProviderID string                 `json:"normalised_provider_transaction_id"`
+}
+
+type truelayerAmount struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
 }
 
 func parseTruelayerTransactions(bank, account string, data []byte) ([]*domain.Transaction, error) {
@@ -85,20 +107,75 @@ func parseTruelayerTransactions(bank, account string, data []byte) ([]*domain.Tr
 
 	txns := []*domain.Transaction{}
 	for _, t := range raw.Results {
-		txns = append(txns, &domain.Transaction{
-			ID:          t.ID,
-			Bank:        bank,
-			Account:     account,
-			Currency:    t.Currency,
-			Timestamp:   t.Timestamp,
-			Description: t.Description,
-			Amount:      t.Amount,
-			Type:        t.Type,
-			Category:    t.Category,
-			Merchant:    t.Merchant,
-			Tags:        t.Classification,
-		})
+		txn := &domain.Transaction{
+			ID:                   t.ID,
+			Bank:                 bank,
+			Account:              account,
+			Currency:             t.Currency,
+			Timestamp:            t.Timestamp,
+			Description:          t.Description,
+			Amount:               t.Amount,
+			Type:                 t.Type,
+			Category:             t.Category,
+			Merchant:             t.Merchant,
+			Tags:                 t.Classification,
+			ProviderID:           t.ProviderID,
+			NormalisedProviderID: t.NormalisedProviderID,
+		}
+
+		extra := map[string]string{}
+		for key, value := range t.Meta {
+			text, err := tlMetaValue(value)
+			if err != nil {
+				return nil, err
+			}
+
+			switch key {
+			case tlMetaCounterparty:
+				txn.Counterparty = text
+			case tlMetaReference:
+				txn.Reference = text
+			default:
+				if text == "" {
+					continue
+				}
+				if renamed, ok := tlMetaRenames[key]; ok {
+					key = renamed
+				}
+				extra[key] = text
+			}
+		}
+
+		if t.RunningBalance != nil {
+			balance := t.RunningBalance.Amount
+			txn.RunningBalance = &balance
+			if t.RunningBalance.Currency != "" && t.RunningBalance.Currency != t.Currency {
+				extra["running_balance_currency"] = t.RunningBalance.Currency
+			}
+		}
+
+		if len(extra) > 0 {
+			txn.Extra = extra
+		}
+		txns = append(txns, txn)
 	}
 
 	return txns, nil
 }
+
+// tlMetaValue flattens a meta value to text. They're almost always strings,
+// but some providers send numbers or objects (eg. a location).
+func tlMetaValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTruelayerTransactions(t *testing.T) {
+	data := []byte(`{"results": [{
+		"transaction_id": "03c333979b729315545816aaa365c33f",
+		"timestamp": "2018-03-06T00:00:00",
+		"description": "GOOGLE PLAY STORE",
+		"amount": -2.99,
+		"currency": "GBP",
+		"transaction_type": "DEBIT",
+		"transaction_category": "PURCHASE",
+		"transaction_classification": ["Entertainment", "Games"],
+		"merchant_name": "Google play",
+		"running_balance": {"amount": 1238.6, "currency": "GBP"},
+		"meta": {
+			"provider_category": "DEB",
+			"provider_reference": "GOOGLE 1234",
+			"counter_party_preferred_name": "Google",
+			"cardNumber": "1234",
+			"transaction_time": "2018-03-06T10:15:00",
+			"location": {"lat": 51.5}
+		},
+		"provider_transaction_id": "a1b2",
+		"normalised_provider_transaction_id": "txn-c3d4"
+	}, {
+		"transaction_id": "2",
+		"timestamp": "2018-03-07T00:00:00",
+		"amount": 10,
+		"currency": "GBP",
+		"running_balance": {"amount": 0, "currency": "EUR"}
+	}]}`)
+
+	txns, err := parseTruelayerTransactions("bank", "acc", data)
+	assert.Nil(t, err)
+	assert.Len(t, txns, 2)
+
+	first := txns[0]
+	assert.Equal(t, "bank", first.Bank)
+	assert.Equal(t, -2.99, first.Amount)
+	assert.Equal(t, 1238.6, *first.RunningBalance)
+	assert.Equal(t, "Google", first.Counterparty)
+	assert.Equal(t, "GOOGLE 1234", first.Reference)
+	assert.Equal(t, "a1b2", first.ProviderID)
+	assert.Equal(t, "txn-c3d4", first.NormalisedProviderID)
+	assert.Equal(t, map[string]string{
+		"provider_category": "DEB",
+		"card_number":       "1234",
+		"transaction_time":  "2018-03-06T10:15:00",
+		"location":          `{"lat":51.5}`,
+	}, first.Extra)
+
+	// a zero balance is still a balance
+	second := txns[1]
+	assert.NotNil(t, second.RunningBalance)
+	assert.Equal(t, 0.0, *second.RunningBalance)
+	assert.Equal(t, map[string]string{"running_balance_currency": "EUR"}, second.Extra)
+}
@@ -0,0 +1,31 @@
+package provider
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoPostRetriesWithBody(t *testing.T) {
+	bodies := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+
+	reply, err := doPost(srv.URL, []byte(`{"grant_type": "refresh_token"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"ok": true}`, string(reply))
+
+	// the retry sends the body again
+	assert.Equal(t, []string{`{"grant_type": "refresh_token"}`, `{"grant_type": "refresh_token"}`}, bodies)
+}
@@ -0,0 +1,38 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	HealthOK      = "ok"
+	HealthFailing = "failing"
+)
+
+// health is the reply to a health check
+type health struct {
+	// Status is HealthFailing if any job's last run failed
+	Status string    `json:"status"`
+	Jobs   []*Status `json:"jobs"`
+}
+
+// ServeHTTP replies with the status of each job & when it's next due, as
+// JSON. We reply 200 even if jobs are failing (eg. a bank is down), as we
+// ourselves are fine.
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reply := &health{Status: HealthOK, Jobs: s.Status()}
+	for _, st := range reply.Jobs {
+		if !st.Healthy() {
+			reply.Status = HealthFailing
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
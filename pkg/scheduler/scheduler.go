@@ -0,0 +1,238 @@
+/*Running jobs on cron schedules*/
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/voidshard/beancounter/pkg/fileutil"
+)
+
+// Schedule says when a job is next due after a given time
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+// Parse reads a standard five field cron spec (minute hour day month
+// weekday), or a descriptor like @daily or @every 6h
+func Parse(spec string) (Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// Job is something to run on a schedule, eg. syncing a connection
+type Job struct {
+	Name     string
+	Schedule Schedule
+
+	// Jitter is the most a run may be put back by, a random amount each
+	// time, so that jobs (& other people's) don't all start at once
+	Jitter time.Duration
+
+	Run func() error
+}
+
+// Status is what we know about a job, & what's kept in the state file
+type Status struct {
+	Name        string    `json:"name"`
+	Running     bool      `json:"running"`
+	LastRun     time.Time `json:"last_run,omitzero"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+	Duration    string    `json:"duration,omitempty"`
+	Failures    int       `json:"failures"` // in a row
+	NextRun     time.Time `json:"next_run,omitzero"`
+}
+
+// Healthy returns if the job's last run (if any) succeeded
+func (s *Status) Healthy() bool {
+	return s.LastError == ""
+}
+
+// Scheduler runs jobs when they're due, one at a time so runs never overlap,
+// keeping how each last went in a state file so that runs missed while we
+// were down happen as soon as we're back.
+type Scheduler struct {
+	filename string
+	now      func() time.Time
+	jitter   func(time.Duration) time.Duration
+
+	jobs []*Job
+
+	// held while a job runs
+	running sync.Mutex
+
+	lock   sync.Mutex
+	status map[string]*Status
+}
+
+// New returns a scheduler keeping state in filename, reading any state
+// already there
+func New(filename string) (*Scheduler, error) {
+	s := &Scheduler{
+		filename: filename,
+		now:      time.Now,
+		jitter:   randomJitter,
+		status:   map[string]*Status{},
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	saved := []*Status{}
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state %s: %v", filename, err)
+	}
+	for _, st := range saved {
+		st.Running = false
+		s.status[st.Name] = st
+	}
+	return s, nil
+}
+
+// Add a job, before Run is called
+func (s *Scheduler) Add(job *Job) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.jobs = append(s.jobs, job)
+	if _, ok := s.status[job.Name]; !ok {
+		s.status[job.Name] = &Status{Name: job.Name}
+	}
+	s.status[job.Name].NextRun = s.due(job, s.status[job.Name])
+}
+
+// Run runs jobs as they fall due until ctx is cancelled, then waits for any
+// running job to finish
+func (s *Scheduler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job *Job) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+	return s.save()
+}
+
+// Status returns the status of each job, by name
+func (s *Scheduler) Status() []*Status {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	all := []*Status{}
+	for _, job := range s.jobs {
+		cp := *s.status[job.Name]
+		all = append(all, &cp)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// loop waits for a job to fall due & runs it, over & over. As one run
+// follows another the job can't overlap with itself.
+func (s *Scheduler) loop(ctx context.Context, job *Job) {
+	for {
+		s.lock.Lock()
+		next := s.status[job.Name].NextRun
+		s.lock.Unlock()
+
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.run(ctx, job)
+	}
+}
+
+// run runs a job once other jobs are done, recording how it went
+func (s *Scheduler) run(ctx context.Context, job *Job) {
+	s.running.Lock()
+	defer s.running.Unlock()
+	if ctx.Err() != nil {
+		return // we were stopped while waiting
+	}
+
+	s.setRunning(job.Name, true)
+	start := s.now()
+	fmt.Println("running", job.Name)
+	err := job.Run()
+
+	s.lock.Lock()
+	st := s.status[job.Name]
+	st.Running = false
+	st.LastRun = start
+	st.Duration = s.now().Sub(start).Round(time.Millisecond).String()
+	if err != nil {
+		st.LastError = err.Error()
+		st.Failures++
+		fmt.Println(job.Name, "failed:", err)
+	} else {
+		st.LastError = ""
+		st.LastSuccess = start
+		st.Failures = 0
+		fmt.Println(job.Name, "done in", st.Duration)
+	}
+	st.NextRun = s.due(job, st)
+	s.lock.Unlock()
+
+	err = s.save()
+	if err != nil {
+		fmt.Println("failed to save state:", err)
+	}
+}
+
+func (s *Scheduler) setRunning(name string, running bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.status[name].Running = running
+}
+
+// due returns when a job should next run; its next scheduled time after it
+// last ran, or now if that's passed (or it's never run), plus some jitter
+func (s *Scheduler) due(job *Job, st *Status) time.Time {
+	now := s.now()
+	next := now
+	if !st.LastRun.IsZero() {
+		next = job.Schedule.Next(st.LastRun)
+		if next.Before(now) {
+			next = now
+		}
+	}
+	return next.Add(s.jitter(job.Jitter))
+}
+
+// save writes the status of every job to our state file
+func (s *Scheduler) save() error {
+	data, err := json.MarshalIndent(s.Status(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(s.filename, data, 0600)
+}
+
+// randomJitter returns a random duration up to max
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,116 @@
+package scheduler
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDue(t *testing.T) {
+	now := time.Date(2020, 7, 1, 12, 30, 0, 0, time.UTC)
+	s, err := New(filepath.Join(t.TempDir(), "state.json"))
+	assert.Nil(t, err)
+	s.now = func() time.Time { return now }
+	s.jitter = func(max time.Duration) time.Duration { return max / 2 }
+
+	daily, err := Parse("0 6 * * *")
+	assert.Nil(t, err)
+	job := &Job{Name: "barclays", Schedule: daily, Jitter: 10 * time.Minute}
+
+	// never run, so straight away (give or take jitter)
+	assert.Equal(t, now.Add(5*time.Minute), s.due(job, &Status{}))
+
+	// ran this morning, so tomorrow morning
+	ranAt := time.Date(2020, 7, 1, 6, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 7, 2, 6, 5, 0, 0, time.UTC), s.due(job, &Status{LastRun: ranAt}))
+
+	// last ran days ago, we missed a run so go now
+	assert.Equal(t, now.Add(5*time.Minute), s.due(job, &Status{LastRun: ranAt.AddDate(0, 0, -3)}))
+
+	_, err = Parse("every tuesday")
+	assert.NotNil(t, err)
+}
+
+func TestRunKeepsState(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+	every, err := Parse("@every 1h")
+	assert.Nil(t, err)
+
+	s, err := New(filename)
+	assert.Nil(t, err)
+
+	// both are due at once, but mustn't run at the same time
+	lock := sync.Mutex{}
+	running := 0
+	overlapped := false
+	run := func(fail bool) func() error {
+		return func() error {
+			lock.Lock()
+			running++
+			overlapped = overlapped || running > 1
+			lock.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+			if fail {
+				return fmt.Errorf("bank is down")
+			}
+			return nil
+		}
+	}
+	s.Add(&Job{Name: "monzo", Schedule: every, Run: run(true)})
+	s.Add(&Job{Name: "barclays", Schedule: every, Run: run(false)})
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	go func() {
+		for {
+			done := 0
+			for _, st := range s.Status() {
+				if !st.LastRun.IsZero() {
+					done++
+				}
+			}
+			if done == 2 {
+				cancel()
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	err = s.Run(ctx)
+	assert.Nil(t, err)
+	assert.False(t, overlapped)
+
+	// a restart picks up where we left off
+	s, err = New(filename)
+	assert.Nil(t, err)
+	s.Add(&Job{Name: "barclays", Schedule: every})
+	s.Add(&Job{Name: "monzo", Schedule: every})
+
+	status := s.Status()
+	assert.Equal(t, "barclays", status[0].Name)
+	assert.True(t, status[0].Healthy())
+	assert.False(t, status[0].LastSuccess.IsZero())
+	assert.Equal(t, "monzo", status[1].Name)
+	assert.Equal(t, "bank is down", status[1].LastError)
+	assert.Equal(t, 1, status[1].Failures)
+	assert.True(t, status[1].NextRun.After(time.Now().Add(59*time.Minute)))
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	reply := &health{}
+	err = json.Unmarshal(w.Body.Bytes(), reply)
+	assert.Nil(t, err)
+	assert.Equal(t, HealthFailing, reply.Status)
+	assert.Len(t, reply.Jobs, 2)
+}
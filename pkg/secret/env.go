@@ -0,0 +1,51 @@
+package secret
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	KindEnv = "env"
+
+	// DefaultEnvPrefix is added to the names of secrets to get environment
+	// variables, eg. truelayer-secret is BEANCOUNTER_TRUELAYER_SECRET
+	DefaultEnvPrefix = "BEANCOUNTER_"
+)
+
+// Env reads secrets from environment variables
+type Env struct {
+	prefix string
+}
+
+// NewEnv returns an environment source, with DefaultEnvPrefix if prefix is
+// not given.
+func NewEnv(prefix string) *Env {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	return &Env{prefix: prefix}
+}
+
+func (e *Env) Get(name string) (string, error) {
+	value := os.Getenv(e.variable(name))
+	if value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (e *Env) String() string {
+	if e.prefix == "" {
+		return "env"
+	}
+	return "env " + e.prefix + "*"
+}
+
+// variable returns the name of the environment variable holding a secret
+func (e *Env) variable(name string) string {
+	if e.prefix == "" {
+		return name
+	}
+	return e.prefix + strings.ToUpper(strings.NewReplacer("-", "_", "/", "_", ".", "_").Replace(name))
+}
@@ -0,0 +1,78 @@
+package secret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const KindFile = "file"
+
+// File reads secrets from files, one secret per file (a trailing newline is
+// dropped). Like ssh we refuse files that anyone but us can read or write.
+type File struct {
+	dir string
+}
+
+// NewFile returns a file source reading dir/<name>, by default
+// $XDG_CONFIG_HOME/beancounter/secrets
+func NewFile(dir string) *File {
+	if dir == "" {
+		config, err := os.UserConfigDir()
+		if err != nil {
+			config = "."
+		}
+		dir = filepath.Join(config, "beancounter", "secrets")
+	}
+	return &File{dir: dir}
+}
+
+func (f *File) Get(name string) (string, error) {
+	filename := name
+	if f.dir != "" {
+		if strings.Contains(name, "..") || filepath.IsAbs(name) {
+			return "", fmt.Errorf("invalid secret name %q", name)
+		}
+		filename = filepath.Join(f.dir, name)
+	}
+
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	err = checkPrivate(filename, info)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func (f *File) String() string {
+	if f.dir == "" {
+		return "file"
+	}
+	return "file " + f.dir
+}
+
+// checkPrivate returns an error if a secret file is open to others
+func checkPrivate(filename string, info os.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", filename)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("permissions %04o for %s are too open, it must only be accessible by its owner (chmod 600)", info.Mode().Perm(), filename)
+	}
+	if !ownedByUs(info) {
+		return fmt.Errorf("%s is not owned by us", filename)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package secret
+
+import (
+	"os"
+)
+
+// ownedByUs can't tell elsewhere, permissions are checked all the same
+func ownedByUs(info os.FileInfo) bool {
+	return true
+}
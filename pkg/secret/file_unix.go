@@ -0,0 +1,14 @@
+//go:build unix
+
+package secret
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownedByUs returns if the file belongs to the user we're running as
+func ownedByUs(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return !ok || int(stat.Uid) == os.Getuid()
+}
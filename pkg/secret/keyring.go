@@ -0,0 +1,44 @@
+package secret
+
+import (
+	"errors"
+
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	KindKeyring = "keyring"
+
+	// DefaultKeyringService is the service our secrets are stored under
+	DefaultKeyringService = "beancounter"
+)
+
+// Keyring reads secrets from the OS keyring; the freedesktop Secret Service
+// (over D-Bus) on Linux, Keychain on macOS. Secrets are stored with the
+// service name & the secret's name as the user, eg.
+//
+//	secret-tool store --label beancounter service beancounter username truelayer-secret
+type Keyring struct {
+	service string
+}
+
+// NewKeyring returns a keyring source, with DefaultKeyringService if
+// service is not given.
+func NewKeyring(service string) *Keyring {
+	if service == "" {
+		service = DefaultKeyringService
+	}
+	return &Keyring{service: service}
+}
+
+func (k *Keyring) Get(name string) (string, error) {
+	value, err := keyring.Get(k.service, name)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func (k *Keyring) String() string {
+	return "keyring " + k.service
+}
@@ -0,0 +1,69 @@
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const (
+	KindPass = "pass"
+
+	// DefaultPassPrefix is the folder of the password store our secrets
+	// are in, eg. truelayer-secret is beancounter/truelayer-secret
+	DefaultPassPrefix = "beancounter"
+)
+
+// Pass reads secrets from the pass password store (passwordstore.org), the
+// first line of an entry being the secret.
+type Pass struct {
+	prefix  string
+	command string
+}
+
+// NewPass returns a pass source, reading entries under DefaultPassPrefix if
+// prefix is not given.
+func NewPass(prefix string) *Pass {
+	if prefix == "" {
+		prefix = DefaultPassPrefix
+	}
+	return &Pass{prefix: prefix, command: "pass"}
+}
+
+func (p *Pass) Get(name string) (string, error) {
+	entry := name
+	if p.prefix != "" && !strings.HasPrefix(name, p.prefix+"/") {
+		entry = path.Join(p.prefix, name)
+	}
+
+	bin, err := exec.LookPath(p.command)
+	if err != nil {
+		return "", fmt.Errorf("%s is not installed", p.command)
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(bin, "show", entry)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if err != nil {
+		if strings.Contains(stderr.String(), "is not in the password store") {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("%s show %s failed: %v %s", p.command, entry, err, strings.TrimSpace(stderr.String()))
+	}
+
+	value := strings.SplitN(stdout.String(), "\n", 2)[0]
+	if value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (p *Pass) String() string {
+	return "pass " + p.prefix + "/"
+}
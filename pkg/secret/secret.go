@@ -0,0 +1,109 @@
+/*Secrets from outside the command line*/
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound is returned when a source has no secret by a name
+var ErrNotFound = errors.New("secret not found")
+
+// Source looks up secrets by name, eg. "truelayer-secret"
+type Source interface {
+	// Get returns the named secret, or ErrNotFound
+	Get(name string) (string, error)
+
+	// String describes where the source looks, for error messages
+	String() string
+}
+
+// Chain asks each source in turn, returning the first secret found.
+type Chain []Source
+
+func (c Chain) Get(name string) (string, error) {
+	problems := []string{}
+	for _, src := range c {
+		value, err := src.Get(name)
+		if err == nil {
+			return value, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			// eg. no D-Bus session, we carry on but say why if nothing else
+			// has the secret
+			problems = append(problems, fmt.Sprintf("%s: %v", src, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return "", fmt.Errorf("%w in %s (%s)", ErrNotFound, c, strings.Join(problems, "; "))
+	}
+	return "", fmt.Errorf("%w in %s", ErrNotFound, c)
+}
+
+func (c Chain) String() string {
+	names := []string{}
+	for _, src := range c {
+		names = append(names, src.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// NewSource returns a source by kind; one of env, file, keyring or pass
+func NewSource(kind string) (Source, error) {
+	switch kind {
+	case KindEnv:
+		return NewEnv(""), nil
+	case KindFile:
+		return NewFile(""), nil
+	case KindKeyring:
+		return NewKeyring(""), nil
+	case KindPass:
+		return NewPass(""), nil
+	}
+	return nil, fmt.Errorf("unknown secret source %q, expected one of %s, %s, %s, %s", kind, KindEnv, KindFile, KindKeyring, KindPass)
+}
+
+// IsReference returns if value refers to a secret (eg. "pass:bank/truelayer")
+// rather than being one
+func IsReference(value string) bool {
+	kind := strings.SplitN(value, ":", 2)[0]
+	switch kind {
+	case KindEnv, KindFile, KindKeyring, KindPass:
+		return strings.Contains(value, ":")
+	}
+	return false
+}
+
+// Resolve returns the secret a reference points at, given as kind:name
+//
+//	env:TRUELAYER_SECRET           the environment variable, as named
+//	file:/path/to/secret           the file (which must be private to us)
+//	keyring:truelayer-secret       the OS keyring / Secret Service
+//	pass:beancounter/truelayer     the pass password store
+func Resolve(ref string) (string, error) {
+	bits := strings.SplitN(ref, ":", 2)
+	if len(bits) != 2 || bits[1] == "" || !IsReference(ref) {
+		return "", fmt.Errorf("invalid secret reference %q, expected env:NAME, file:/path, keyring:name or pass:path", ref)
+	}
+
+	var src Source
+	name := bits[1]
+	switch bits[0] {
+	case KindEnv:
+		src = &Env{} // named exactly, without our prefix
+	case KindFile:
+		src = &File{}
+	case KindKeyring:
+		src = NewKeyring("")
+	case KindPass:
+		src = &Pass{command: "pass"} // the entry as named
+	}
+
+	value, err := src.Get(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", ref, err)
+	}
+	return value, nil
+}
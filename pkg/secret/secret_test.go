@@ -0,0 +1,124 @@
+package secret
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnv(t *testing.T) {
+	os.Setenv("BEANCOUNTER_TRUELAYER_SECRET", "shh")
+	defer os.Unsetenv("BEANCOUNTER_TRUELAYER_SECRET")
+
+	value, err := NewEnv("").Get("truelayer-secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "shh", value)
+
+	_, err = NewEnv("").Get("nope")
+	assert.Equal(t, ErrNotFound, err)
+
+	value, err = Resolve("env:BEANCOUNTER_TRUELAYER_SECRET")
+	assert.Nil(t, err)
+	assert.Equal(t, "shh", value)
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src := NewFile(dir)
+	_, err = src.Get("truelayer-secret")
+	assert.Equal(t, ErrNotFound, err)
+
+	filename := filepath.Join(dir, "truelayer-secret")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("shh\n"), 0644))
+	_, err = src.Get("truelayer-secret")
+	assert.Contains(t, err.Error(), "too open")
+
+	assert.Nil(t, os.Chmod(filename, 0600))
+	value, err := src.Get("truelayer-secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "shh", value)
+
+	value, err = Resolve("file:" + filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "shh", value)
+
+	_, err = src.Get("../secrets/truelayer-secret")
+	assert.NotNil(t, err)
+}
+
+func TestPass(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// a stand in for pass, knowing one entry
+	script := `#!/bin/sh
+if [ "$2" = "beancounter/truelayer-secret" ]; then
+	printf 'shh\nuser: me\n'
+else
+	echo "Error: $2 is not in the password store." >&2
+	exit 1
+fi
+`
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fakepass"), []byte(script), 0700))
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	src := NewPass("")
+	src.command = "fakepass"
+
+	value, err := src.Get("truelayer-secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "shh", value)
+
+	_, err = src.Get("nope")
+	assert.Equal(t, ErrNotFound, err)
+
+	src.command = "not-installed-anywhere"
+	_, err = src.Get("truelayer-secret")
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrNotFound))
+}
+
+// broken is a source that can't be reached, eg. the keyring without D-Bus
+type broken struct{}
+
+func (broken) Get(string) (string, error) { return "", errors.New("no session bus") }
+func (broken) String() string             { return "broken" }
+
+func TestChain(t *testing.T) {
+	os.Setenv("BEANCOUNTER_PASSPHRASE", "open sesame")
+	defer os.Unsetenv("BEANCOUNTER_PASSPHRASE")
+
+	chain := Chain{broken{}, NewEnv("")}
+	value, err := chain.Get("passphrase")
+	assert.Nil(t, err)
+	assert.Equal(t, "open sesame", value)
+
+	_, err = chain.Get("nope")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Contains(t, err.Error(), "no session bus")
+}
+
+func TestReferences(t *testing.T) {
+	assert.True(t, IsReference("pass:bank/truelayer"))
+	assert.True(t, IsReference("env:SECRET"))
+	assert.False(t, IsReference("hunter2"))
+	assert.False(t, IsReference("passw0rd:123"))
+
+	_, err := Resolve("hunter2")
+	assert.NotNil(t, err)
+	_, err = Resolve("env:")
+	assert.NotNil(t, err)
+
+	_, err = NewSource("vault")
+	assert.NotNil(t, err)
+}
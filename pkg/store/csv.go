@@ -0,0 +1,275 @@
+package store
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+const (
+	SplitNone    = ""
+	SplitAccount = "account"
+	SplitMonth   = "month"
+)
+
+var (
+	// CSVColumns are the columns available to CSV, in their default order
+	CSVColumns = []string{
+		"id", "bank", "account", "currency", "timestamp", "description",
+		"amount", "type", "category", "merchant", "tags",
+		"running_balance", "counterparty", "reference", "provider_id",
+		"normalised_provider_id", "extra",
+	}
+
+	// characters that spreadsheets may take to mean "this is a formula"
+	csvFormulaPrefixes = "=+-@\t\r"
+
+	csvUnsafeFilename = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// CSVConfig controls the output of the CSV store, zero values give defaults.
+type CSVConfig struct {
+	// Columns to write, in order (see CSVColumns)
+	Columns []string
+
+	// Delimiter between fields, default ','
+	Delimiter rune
+
+	// Decimal separator for amounts, default "."
+	Decimal string
+
+	// DateFormat is a Go time layout for timestamps, default "2006-01-02"
+	DateFormat string
+
+	// TagSeparator joins tags into one field, default "|"
+	TagSeparator string
+
+	// Split the output into one file per account or month
+	Split string
+}
+
+// CSV writes transactions as CSV for spreadsheets.
+type CSV struct {
+	filename string
+	cfg      *CSVConfig
+	cipher   Cipher
+}
+
+func NewCSV(filename string, cfg *CSVConfig) (Store, error) {
+	final := &CSVConfig{
+		Columns:      CSVColumns,
+		Delimiter:    ',',
+		Decimal:      ".",
+		DateFormat:   "2006-01-02",
+		TagSeparator: "|",
+	}
+	if cfg != nil {
+		if len(cfg.Columns) > 0 {
+			final.Columns = cfg.Columns
+		}
+		if cfg.Delimiter != 0 {
+			final.Delimiter = cfg.Delimiter
+		}
+		if cfg.Decimal != "" {
+			final.Decimal = cfg.Decimal
+		}
+		if cfg.DateFormat != "" {
+			final.DateFormat = cfg.DateFormat
+		}
+		if cfg.TagSeparator != "" {
+			final.TagSeparator = cfg.TagSeparator
+		}
+		final.Split = cfg.Split
+	}
+
+	for _, col := range final.Columns {
+		if !csvKnownColumn(col) {
+			return nil, fmt.Errorf("unknown csv column %q, expected one of %s", col, strings.Join(CSVColumns, ", "))
+		}
+	}
+
+	switch final.Split {
+	case SplitNone, SplitAccount, SplitMonth:
+	default:
+		return nil, fmt.Errorf("unknown csv split %q, expected one of %s, %s", final.Split, SplitAccount, SplitMonth)
+	}
+
+	return &CSV{filename: filename, cfg: final}, nil
+}
+
+func (c *CSV) Write(txns []*domain.Transaction) error {
+	files := map[string][]*domain.Transaction{}
+	for _, t := range txns {
+		name, err := c.splitFilename(t)
+		if err != nil {
+			return err
+		}
+		files[name] = append(files[name], t)
+	}
+	if len(files) == 0 {
+		files[c.filename] = txns // we should still write the header
+	}
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := c.render(files[name])
+		if err != nil {
+			return err
+		}
+
+		err = writeFileSealed(name, data, 0644, c.cipher)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetCipher has each file encrypted
+func (c *CSV) SetCipher(cipher Cipher) {
+	c.cipher = cipher
+}
+
+// render returns transactions as CSV, with a header
+func (c *CSV) render(txns []*domain.Transaction) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	w := csv.NewWriter(buf)
+	w.Comma = c.cfg.Delimiter
+
+	err := w.Write(c.cfg.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range txns {
+		row := []string{}
+		for _, col := range c.cfg.Columns {
+			value, err := c.field(t, col)
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, value)
+		}
+
+		err = w.Write(row)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// field returns the value of the given column for a transaction
+func (c *CSV) field(t *domain.Transaction, col string) (string, error) {
+	switch col {
+	case "amount":
+		return c.amount(t.Amount), nil
+	case "running_balance":
+		if t.RunningBalance == nil {
+			return "", nil
+		}
+		return c.amount(*t.RunningBalance), nil
+	case "timestamp":
+		ts, err := t.Time()
+		if err != nil {
+			return "", err
+		}
+		return ts.Format(c.cfg.DateFormat), nil
+	case "id":
+		return csvSafe(t.ID), nil
+	case "bank":
+		return csvSafe(t.Bank), nil
+	case "account":
+		return csvSafe(t.Account), nil
+	case "currency":
+		return csvSafe(t.Currency), nil
+	case "description":
+		return csvSafe(t.Description), nil
+	case "type":
+		return csvSafe(t.Type), nil
+	case "category":
+		return csvSafe(t.Category), nil
+	case "merchant":
+		return csvSafe(t.Merchant), nil
+	case "tags":
+		return csvSafe(strings.Join(t.Tags, c.cfg.TagSeparator)), nil
+	case "counterparty":
+		return csvSafe(t.Counterparty), nil
+	case "reference":
+		return csvSafe(t.Reference), nil
+	case "provider_id":
+		return csvSafe(t.ProviderID), nil
+	case "normalised_provider_id":
+		return csvSafe(t.NormalisedProviderID), nil
+	case "extra":
+		// key=value pairs, sorted so the output is stable
+		pairs := []string{}
+		for key, value := range t.Extra {
+			pairs = append(pairs, key+"="+value)
+		}
+		sort.Strings(pairs)
+		return csvSafe(strings.Join(pairs, c.cfg.TagSeparator)), nil
+	}
+	return "", fmt.Errorf("unknown csv column %q", col)
+}
+
+// amount formats an amount with our decimal separator
+func (c *CSV) amount(value float64) string {
+	return strings.Replace(strconv.FormatFloat(value, 'f', 2, 64), ".", c.cfg.Decimal, 1)
+}
+
+// splitFilename returns the file the transaction should be written to
+func (c *CSV) splitFilename(t *domain.Transaction) (string, error) {
+	suffix := ""
+	switch c.cfg.Split {
+	case SplitAccount:
+		suffix = fmt.Sprintf("%s-%s", t.Bank, t.Account)
+	case SplitMonth:
+		ts, err := t.Time()
+		if err != nil {
+			return "", err
+		}
+		suffix = ts.Format("2006-01")
+	default:
+		return c.filename, nil
+	}
+
+	suffix = strings.Trim(csvUnsafeFilename.ReplaceAllString(suffix, "_"), "_")
+	ext := filepath.Ext(c.filename)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(c.filename, ext), suffix, ext), nil
+}
+
+// csvSafe stops spreadsheets from treating text as a formula, by prefixing
+// a ' as OWASP recommends. Bank descriptions are, after all, chosen by
+// whoever we're paying.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsAny(s[:1], csvFormulaPrefixes) {
+		return "'" + s
+	}
+	return s
+}
+
+func csvKnownColumn(col string) bool {
+	for _, known := range CSVColumns {
+		if col == known {
+			return true
+		}
+	}
+	return false
+}
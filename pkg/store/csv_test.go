@@ -0,0 +1,90 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/crypto"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+func TestCSVWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	out, err := NewCSV(filepath.Join(dir, "out.csv"), &CSVConfig{
+		Columns:    []string{"timestamp", "description", "amount", "tags"},
+		Delimiter:  ';',
+		Decimal:    ",",
+		DateFormat: "02/01/2006",
+		Split:      SplitMonth,
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, out.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "1", Timestamp: "2020-07-01T00:00:00Z", Description: "=HYPERLINK(\"x\")", Amount: -1234.5, Tags: []string{"a", "b"}},
+		&domain.Transaction{ID: "2", Timestamp: "2020-08-01T00:00:00Z", Description: "Rent", Amount: 10},
+	}))
+
+	july, err := ioutil.ReadFile(filepath.Join(dir, "out-2020-07.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, "timestamp;description;amount;tags\n01/07/2020;\"'=HYPERLINK(\"\"x\"\")\";-1234,50;a|b\n", string(july))
+
+	august, err := ioutil.ReadFile(filepath.Join(dir, "out-2020-08.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, "timestamp;description;amount;tags\n01/08/2020;Rent;10,00;\n", string(august))
+}
+
+func TestCSVProviderColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "out.csv")
+	out, err := NewCSV(filename, &CSVConfig{
+		Columns: []string{"id", "running_balance", "counterparty", "reference", "extra"},
+	})
+	assert.Nil(t, err)
+
+	balance := 1238.6
+	assert.Nil(t, out.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "1", Timestamp: "2020-07-01", RunningBalance: &balance, Counterparty: "Google", Reference: "-REF",
+			Extra: map[string]string{"provider_category": "DEB", "card_number": "1234"}},
+		&domain.Transaction{ID: "2", Timestamp: "2020-07-02"},
+	}))
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "id,running_balance,counterparty,reference,extra\n1,1238.60,Google,'-REF,card_number=1234|provider_category=DEB\n2,,,,\n", string(data))
+}
+
+func TestCSVUnknownColumn(t *testing.T) {
+	_, err := NewCSV("out.csv", &CSVConfig{Columns: []string{"nope"}})
+	assert.NotNil(t, err)
+}
+
+func TestCSVEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cipher := crypto.Passphrase("pass")
+	filename := filepath.Join(dir, "test.csv")
+	c, err := NewCSV(filename, &CSVConfig{Columns: []string{"id", "description"}})
+	assert.Nil(t, err)
+	c.(Encryptable).SetCipher(cipher)
+
+	assert.Nil(t, c.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "1", Timestamp: "2020-07-01T00:00:00Z", Description: "Rent"},
+	}))
+
+	sealed, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	plain, err := cipher.Open(sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, "id,description\n1,Rent\n", string(plain))
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// BulkFailure describes a document that failed to index
+type BulkFailure struct {
+	ID     string
+	Index  string
+	Status int
+	Type   string
+	Reason string
+}
+
+func (f *BulkFailure) String() string {
+	return fmt.Sprintf("transaction %s (index %s): [%d] %s: %s", f.ID, f.Index, f.Status, f.Type, f.Reason)
+}
+
+// BulkError is returned when one or more documents fail to index, with
+// the details of each failure.
+type BulkError struct {
+	Indexed  int64
+	Failures []*BulkFailure
+}
+
+func (e *BulkError) Error() string {
+	lines := []string{
+		fmt.Sprintf("failed to index %d documents (%d indexed ok)", len(e.Failures), e.Indexed),
+	}
+	for _, f := range e.Failures {
+		lines = append(lines, f.String())
+	}
+	return strings.Join(lines, "\n\t")
+}
+
+// bulkFailures collects failures reported (concurrently) by bulk workers
+type bulkFailures struct {
+	lock     sync.Mutex
+	failures []*BulkFailure
+}
+
+func (b *bulkFailures) add(f *BulkFailure) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures = append(b.failures, f)
+}
+
+// err returns a *BulkError if there were any failures
+func (b *bulkFailures) err(indexed int64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.failures) == 0 {
+		return nil
+	}
+	return &BulkError{Indexed: indexed, Failures: b.failures}
+}
+
+// bulkItemFailure describes a failed item from the parts of a bulk
+// response item, which the elasticsearch & opensearch clients share
+func bulkItemFailure(id, index string, status int, kind, reason, causeKind, causeReason string, err error) *BulkFailure {
+	failure := &BulkFailure{
+		ID:     id,
+		Index:  index,
+		Status: status,
+		Type:   kind,
+		Reason: reason,
+	}
+	if err != nil {
+		failure.Type = "request"
+		failure.Reason = err.Error()
+	} else if causeReason != "" {
+		failure.Reason = fmt.Sprintf("%s (caused by %s: %s)", reason, causeKind, causeReason)
+	}
+	return failure
+}
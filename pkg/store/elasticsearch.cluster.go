@@ -0,0 +1,564 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+// Index setup, mapping upgrades & searches for Elasticsearch & OpenSearch.
+// These are plain REST calls that both APIs share, so they're made with
+// whichever client the store has via Perform.
+
+const (
+	// esPage is how many hits we ask for per search request
+	esPage = 1000
+)
+
+// esTransport is implemented by both the elasticsearch & opensearch clients
+type esTransport interface {
+	Perform(*http.Request) (*http.Response, error)
+}
+
+// esCluster is the client agnostic part of a search store
+type esCluster struct {
+	cfg *ElasticsearchConfig
+}
+
+// targets returns the index (or write alias) each transaction belongs in,
+// and the set of all of them
+func (c *esCluster) targets(txns []*domain.Transaction) (map[*domain.Transaction]string, []string, error) {
+	indices := map[*domain.Transaction]string{}
+	targets := []string{}
+	for _, t := range txns {
+		name, err := c.cfg.indexName(t)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !esContains(targets, name) {
+			targets = append(targets, name)
+		}
+		indices[t] = name
+	}
+	return indices, targets, nil
+}
+
+// setup installs our index template & lifecycle policy, then makes sure
+// the given indices exist & that the mappings of all our indices are up
+// to date.
+func (c *esCluster) setup(es esTransport, targets []string) error {
+	if c.cfg.Lifecycle != nil {
+		_, _, err := esRequest(es, "PUT", "/_ilm/policy/"+url.PathEscape(c.cfg.Lifecycle.Policy), nil, c.cfg.Lifecycle.policy())
+		if err != nil {
+			return fmt.Errorf("failed to install lifecycle policy %s: %v", c.cfg.Lifecycle.Policy, err)
+		}
+	}
+
+	err := putTemplate(es, c.cfg.Alias, esIndexTemplate(c.cfg))
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		err = c.ensureIndex(es, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.upgradeMappings(es, c.cfg.Alias)
+}
+
+// putTemplate installs (or upgrades) an index template of ours. Our main
+// template is named after our alias, since it's what ties our indices
+// together.
+func putTemplate(es esTransport, name string, template map[string]interface{}) error {
+	hash := template["_meta"].(map[string]interface{})["config"].(string)
+
+	status, body, err := esRequest(es, "GET", "/_index_template/"+url.PathEscape(name), nil, nil)
+	if err != nil && status != 404 {
+		return err
+	}
+
+	if status == 200 {
+		reply := struct {
+			IndexTemplates []struct {
+				IndexTemplate struct {
+					Version int `json:"version"`
+					Meta    struct {
+						Config string `json:"config"`
+					} `json:"_meta"`
+				} `json:"index_template"`
+			} `json:"index_templates"`
+		}{}
+
+		err = json.Unmarshal(body, &reply)
+		if err != nil {
+			return err
+		}
+		if len(reply.IndexTemplates) > 0 {
+			current := reply.IndexTemplates[0].IndexTemplate
+			if current.Version >= esMappingVersion && current.Meta.Config == hash {
+				return nil // up to date
+			}
+		}
+	}
+
+	log.Printf("installing index template %s (version %d)\n", name, esMappingVersion)
+	_, _, err = esRequest(es, "PUT", "/_index_template/"+url.PathEscape(name), nil, template)
+	if err != nil {
+		return fmt.Errorf("failed to install index template: %v", err)
+	}
+	return nil
+}
+
+// ensureIndex creates the index (or write alias, if we're rolling over)
+// if need be & makes sure it's included in our alias.
+func (c *esCluster) ensureIndex(es esTransport, index string) error {
+	if c.cfg.rollover() {
+		// indices made by rollover get their settings from templates only,
+		// so each write alias needs one naming it
+		err := putTemplate(es, index+"-rollover", esRolloverTemplate(c.cfg, index))
+		if err != nil {
+			return err
+		}
+	}
+
+	status, _, err := esRequest(es, "HEAD", "/"+url.PathEscape(index), nil, nil)
+	if err != nil && status != 404 {
+		return err
+	}
+
+	if status == 404 {
+		if !c.cfg.rollover() {
+			return esCreateIndex(es, index, map[string]interface{}{
+				c.cfg.Alias: map[string]interface{}{},
+			})
+		}
+
+		// bootstrap the first index behind the write alias
+		return esCreateIndex(es, index+"-000001", map[string]interface{}{
+			index:       map[string]interface{}{"is_write_index": true},
+			c.cfg.Alias: map[string]interface{}{},
+		})
+	}
+
+	// an index that predates our alias
+	_, _, err = esRequest(es, "POST", "/_aliases", nil, map[string]interface{}{
+		"actions": []interface{}{
+			map[string]interface{}{"add": map[string]string{"index": index, "alias": c.cfg.Alias}},
+		},
+	})
+	return err
+}
+
+// upgradeMappings brings the mapping of every index behind the given
+// name (index, alias or pattern) up to date.
+func (c *esCluster) upgradeMappings(es esTransport, name string) error {
+	_, body, err := esRequest(es, "GET", "/"+url.PathEscape(name)+"/_mapping", url.Values{
+		"ignore_unavailable": {"true"},
+		"allow_no_indices":   {"true"},
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	reply := map[string]struct {
+		Mappings struct {
+			Meta struct {
+				Version int `json:"version"`
+			} `json:"_meta"`
+		} `json:"mappings"`
+	}{}
+	err = json.Unmarshal(body, &reply)
+	if err != nil {
+		return err
+	}
+
+	for index, mapping := range reply {
+		version := mapping.Mappings.Meta.Version
+		if version >= esMappingVersion {
+			continue // up to date
+		}
+
+		// new fields can be added to an existing mapping, but changing the
+		// type of a field (eg. from a guessed "text" to "keyword") cannot
+		log.Printf("updating mapping of %s from version %d to %d\n", index, version, esMappingVersion)
+		_, _, err = esRequest(es, "PUT", "/"+url.PathEscape(index)+"/_mapping", nil, esMappings())
+		if err == nil {
+			continue
+		}
+
+		log.Printf("mapping of %s cannot be updated in place (%v), reindexing\n", index, err)
+		err = esReindexUpgrade(es, index)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// query returns transactions matching a query, oldest first. Reads never
+// set anything up, until we've written there's simply nothing to find.
+func (c *esCluster) query(es esTransport, q *Query) ([]*domain.Transaction, error) {
+	body := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{"filter": esFilters(q)},
+		},
+		"sort": []interface{}{
+			map[string]string{"timestamp": "asc"},
+			map[string]string{"id": "asc"},
+		},
+	}
+
+	txns := []*domain.Transaction{}
+	for {
+		size := esPage
+		if q.Limit > 0 && q.Limit-len(txns) < size {
+			size = q.Limit - len(txns)
+		}
+		body["size"] = size
+
+		reply, err := esSearch(es, c.cfg.Alias, body)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hit := range reply.Hits.Hits {
+			t := &domain.Transaction{}
+			err = json.Unmarshal(hit.Source, t)
+			if err != nil {
+				return nil, err
+			}
+			txns = append(txns, t)
+		}
+
+		if len(reply.Hits.Hits) < size || (q.Limit > 0 && len(txns) >= q.Limit) {
+			return txns, nil
+		}
+
+		// carry on from the last hit
+		body["search_after"] = reply.Hits.Hits[len(reply.Hits.Hits)-1].Sort
+	}
+}
+
+// latest returns the most recent transaction of each account
+func (c *esCluster) latest(es esTransport) ([]*domain.Transaction, error) {
+	accounts := map[string]interface{}{
+		"size": esPage,
+		"sources": []interface{}{
+			map[string]interface{}{"bank": map[string]interface{}{"terms": map[string]string{"field": "bank"}}},
+			map[string]interface{}{"account": map[string]interface{}{"terms": map[string]string{"field": "account"}}},
+		},
+	}
+	body := map[string]interface{}{
+		"size": 0,
+		"aggs": map[string]interface{}{
+			"accounts": map[string]interface{}{
+				"composite": accounts,
+				"aggs": map[string]interface{}{
+					"latest": map[string]interface{}{
+						"top_hits": map[string]interface{}{
+							"size": 1,
+							"sort": []interface{}{map[string]string{"timestamp": "desc"}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	txns := []*domain.Transaction{}
+	for {
+		reply, err := esSearch(es, c.cfg.Alias, body)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, bucket := range reply.Aggregations.Accounts.Buckets {
+			for _, hit := range bucket.Latest.Hits.Hits {
+				t := &domain.Transaction{}
+				err = json.Unmarshal(hit.Source, t)
+				if err != nil {
+					return nil, err
+				}
+				txns = append(txns, t)
+			}
+		}
+
+		if reply.Aggregations.Accounts.AfterKey == nil {
+			return txns, nil
+		}
+		accounts["after"] = reply.Aggregations.Accounts.AfterKey
+	}
+}
+
+// esHits are the hits of a search, or top_hits aggregation
+type esHits struct {
+	Hits []struct {
+		Source json.RawMessage `json:"_source"`
+		Sort   []interface{}   `json:"sort"`
+	} `json:"hits"`
+}
+
+// esSearchReply holds the parts of a search reply we're interested in
+type esSearchReply struct {
+	Hits         esHits `json:"hits"`
+	Aggregations struct {
+		Accounts struct {
+			AfterKey map[string]interface{} `json:"after_key"`
+			Buckets  []struct {
+				Latest struct {
+					Hits esHits `json:"hits"`
+				} `json:"latest"`
+			} `json:"buckets"`
+		} `json:"accounts"`
+	} `json:"aggregations"`
+}
+
+// esSearch runs a search against an index (or alias)
+func esSearch(es esTransport, index string, body map[string]interface{}) (*esSearchReply, error) {
+	_, data, err := esRequest(es, "POST", "/"+url.PathEscape(index)+"/_search", url.Values{
+		"ignore_unavailable": {"true"}, // no index, no results
+	}, body)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %v", err)
+	}
+
+	reply := &esSearchReply{}
+	err = json.Unmarshal(data, reply)
+	return reply, err
+}
+
+// esFilters returns filter clauses for the given query
+func esFilters(q *Query) []interface{} {
+	filters := []interface{}{}
+
+	term := func(field, value string) {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]string{field: value},
+		})
+	}
+
+	if q.Bank != "" {
+		term("bank", q.Bank)
+	}
+	if q.Account != "" {
+		term("account", q.Account)
+	}
+	if q.Category != "" {
+		term("category", q.Category)
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		between := map[string]string{}
+		if !q.From.IsZero() {
+			between["gte"] = q.From.Format(time.RFC3339)
+		}
+		if !q.To.IsZero() {
+			between["lt"] = q.To.Format(time.RFC3339)
+		}
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{"timestamp": between},
+		})
+	}
+	if q.Text != "" {
+		// a case insensitive substring of either, as the other readers do
+		contains := func(field string) map[string]interface{} {
+			return map[string]interface{}{
+				"wildcard": map[string]interface{}{
+					field: map[string]interface{}{
+						"value":            "*" + esWildcardEscape(q.Text) + "*",
+						"case_insensitive": true,
+					},
+				},
+			}
+		}
+		filters = append(filters, map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					contains("description.keyword"),
+					contains("merchant"),
+					// description.keyword skips descriptions over 512
+					// chars, the analysed field still has their words
+					map[string]interface{}{
+						"match_phrase": map[string]string{"description": q.Text},
+					},
+				},
+				"minimum_should_match": 1,
+			},
+		})
+	}
+
+	return filters
+}
+
+// esWildcardEscape escapes the characters special to a wildcard query, so
+// that text is matched as is
+func esWildcardEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(text)
+}
+
+// esRequest makes a REST call, returning the response status & body. An
+// error is returned if the request failed or the status isn't 2xx.
+func esRequest(es esTransport, method, path string, params url.Values, body interface{}) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(method, path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := es.Perform(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+	if res.StatusCode > 299 {
+		return res.StatusCode, data, fmt.Errorf("%d %s: %s", res.StatusCode, http.StatusText(res.StatusCode), string(data))
+	}
+	return res.StatusCode, data, nil
+}
+
+// esCreateIndex creates an index with our mappings, and the given aliases
+// & settings (if any)
+func esCreateIndex(es esTransport, index string, aliases map[string]interface{}) error {
+	create := map[string]interface{}{"mappings": esMappings()}
+	if aliases != nil {
+		create["aliases"] = aliases
+	}
+
+	_, body, err := esRequest(es, "PUT", "/"+url.PathEscape(index), nil, create)
+	if err != nil && bytes.Contains(body, []byte("resource_already_exists_exception")) {
+		return nil // someone beat us to it
+	}
+	return err
+}
+
+// esReindexUpgrade rebuilds an index with our current mappings by copying
+// the data out to a temporary index, recreating the index (with the same
+// aliases) & copying it back.
+func esReindexUpgrade(es esTransport, index string) error {
+	// named so that our index template won't apply to it
+	tmp := fmt.Sprintf("upgrade-%s-v%d", index, esMappingVersion)
+
+	_, body, err := esRequest(es, "GET", "/"+url.PathEscape(index)+"/_alias", nil, nil)
+	if err != nil {
+		return err
+	}
+	aliases := map[string]struct {
+		Aliases map[string]interface{} `json:"aliases"`
+	}{}
+	err = json.Unmarshal(body, &aliases)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = esRequest(es, "DELETE", "/"+url.PathEscape(tmp), url.Values{"ignore_unavailable": {"true"}}, nil)
+	if err != nil {
+		return err
+	}
+	err = esCreateIndex(es, tmp, nil)
+	if err != nil {
+		return err
+	}
+
+	err = esReindex(es, index, tmp)
+	if err != nil {
+		return err
+	}
+
+	// only once we're sure the copy is complete do we remove the original
+	before, err := esCount(es, index)
+	if err != nil {
+		return err
+	}
+	after, err := esCount(es, tmp)
+	if err != nil {
+		return err
+	}
+	if before != after {
+		return fmt.Errorf("reindexing %s into %s copied %d of %d documents, leaving both in place", index, tmp, after, before)
+	}
+
+	_, _, err = esRequest(es, "DELETE", "/"+url.PathEscape(index), nil, nil)
+	if err != nil {
+		return err
+	}
+	err = esCreateIndex(es, index, aliases[index].Aliases)
+	if err != nil {
+		return err
+	}
+	err = esReindex(es, tmp, index)
+	if err != nil {
+		return fmt.Errorf("failed to copy data back from %s into %s (your data is safe in %s): %v", tmp, index, tmp, err)
+	}
+
+	_, _, err = esRequest(es, "DELETE", "/"+url.PathEscape(tmp), nil, nil)
+	return err
+}
+
+// esReindex copies all documents from one index to another
+func esReindex(es esTransport, from, to string) error {
+	_, _, err := esRequest(es, "POST", "/_reindex", url.Values{
+		"wait_for_completion": {"true"},
+		"refresh":             {"true"},
+	}, map[string]interface{}{
+		"source": map[string]string{"index": from},
+		"dest":   map[string]string{"index": to},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reindex %s into %s: %v", from, to, err)
+	}
+	return nil
+}
+
+// esCount returns the number of documents in an index
+func esCount(es esTransport, index string) (int64, error) {
+	_, body, err := esRequest(es, "GET", "/"+url.PathEscape(index)+"/_count", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	reply := struct {
+		Count int64 `json:"count"`
+	}{}
+	err = json.Unmarshal(body, &reply)
+	return reply.Count, err
+}
+
+func esContains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
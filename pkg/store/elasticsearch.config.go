@@ -0,0 +1,227 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+const (
+	// DefaultESIndex is the index we write to if not told otherwise
+	DefaultESIndex = "beancounter"
+
+	// DefaultESAlias spans all of the indices we write to
+	DefaultESAlias = "beancounter-all"
+
+	// bulk indexing defaults
+	defaultESFlushBytes    = 2048
+	defaultESWorkers       = 4
+	defaultESFlushInterval = 10 * time.Second
+)
+
+var (
+	esPlaceholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+	// characters permitted in index names (which must also be lowercase)
+	esUnsafeIndex = regexp.MustCompile(`[^a-z0-9_-]+`)
+)
+
+// ElasticsearchConfig configures an Elasticsearch store. Zero values give
+// defaults.
+type ElasticsearchConfig struct {
+	Addresses []string
+
+	// CloudID of an Elastic Cloud deployment, used instead of Addresses
+	CloudID string
+
+	// Username & Password for basic auth, or an APIKey (base64 encoded
+	// id:api_key) which takes precedence
+	Username string
+	Password string
+	APIKey   string
+
+	// CACert is a PEM encoded certificate authority to trust, eg. for a
+	// cluster with self signed certificates
+	CACert []byte
+
+	// Bulk indexing settings
+	FlushBytes    int
+	FlushInterval time.Duration
+	Workers       int
+
+	// Index names the index a transaction is written to. It may include
+	// {bank} {account} {yyyy} and {mm}, eg. beancounter-{bank}-{yyyy}
+	Index string
+
+	// Alias is added to every index we write to, so that they can all be
+	// read as one
+	Alias string
+
+	// Lifecycle (optional) sets up an ILM policy for our indices
+	Lifecycle *ESLifecycle
+}
+
+// ESLifecycle describes an index lifecycle (ILM) policy.
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.8/index-lifecycle-management.html
+type ESLifecycle struct {
+	// Policy is the name of the ILM policy
+	Policy string
+
+	// RolloverMaxAge and / or RolloverMaxSize (eg. 365d, 5gb) enable
+	// rollover. Each index name then becomes a write alias for a series
+	// of indices <name>-000001, <name>-000002 ...
+	RolloverMaxAge  string
+	RolloverMaxSize string
+
+	// DeleteAfter (eg. 3650d) deletes indices this long after they're
+	// created, or rolled over.
+	DeleteAfter string
+}
+
+// withDefaults returns a copy of the config with defaults filled in
+func (c *ElasticsearchConfig) withDefaults() *ElasticsearchConfig {
+	final := &ElasticsearchConfig{
+		Index:         DefaultESIndex,
+		Alias:         DefaultESAlias,
+		FlushBytes:    defaultESFlushBytes,
+		FlushInterval: defaultESFlushInterval,
+		Workers:       defaultESWorkers,
+	}
+	if c == nil {
+		return final
+	}
+
+	final.Addresses = c.Addresses
+	final.CloudID = c.CloudID
+	final.Username = c.Username
+	final.Password = c.Password
+	final.APIKey = c.APIKey
+	final.CACert = c.CACert
+	if c.FlushBytes > 0 {
+		final.FlushBytes = c.FlushBytes
+	}
+	if c.FlushInterval > 0 {
+		final.FlushInterval = c.FlushInterval
+	}
+	if c.Workers > 0 {
+		final.Workers = c.Workers
+	}
+	if c.Index != "" {
+		final.Index = c.Index
+	}
+	if c.Alias != "" {
+		final.Alias = c.Alias
+	}
+	if c.Lifecycle != nil && c.Lifecycle.Policy != "" {
+		final.Lifecycle = c.Lifecycle
+	}
+	return final
+}
+
+// validate checks the config makes sense
+func (c *ElasticsearchConfig) validate() error {
+	if len(c.Addresses) == 0 && c.CloudID == "" {
+		return fmt.Errorf("either an address or a cloud id is required")
+	}
+	if strings.HasPrefix(c.Index, "{") {
+		// our template would match every index
+		return fmt.Errorf("index %q must start with a fixed prefix, eg. beancounter-{bank}", c.Index)
+	}
+	for _, placeholder := range esPlaceholder.FindAllString(c.Index, -1) {
+		switch placeholder {
+		case "{bank}", "{account}", "{yyyy}", "{mm}":
+		default:
+			return fmt.Errorf("index %q has unknown placeholder %s, expected {bank} {account} {yyyy} or {mm}", c.Index, placeholder)
+		}
+	}
+	if c.Alias == c.Index {
+		return fmt.Errorf("alias and index cannot have the same name %q", c.Alias)
+	}
+	return nil
+}
+
+// rollover returns if indices roll over, in which case index names are
+// really write aliases.
+func (c *ElasticsearchConfig) rollover() bool {
+	return c.Lifecycle != nil && (c.Lifecycle.RolloverMaxAge != "" || c.Lifecycle.RolloverMaxSize != "")
+}
+
+// indexName returns the name of the index a transaction should be written to
+func (c *ElasticsearchConfig) indexName(t *domain.Transaction) (string, error) {
+	if !strings.Contains(c.Index, "{") {
+		return c.Index, nil
+	}
+
+	ts, err := t.Time()
+	if err != nil {
+		return "", err
+	}
+	ts = ts.UTC()
+
+	return strings.NewReplacer(
+		"{bank}", esIndexPart(t.Bank),
+		"{account}", esIndexPart(t.Account),
+		"{yyyy}", fmt.Sprintf("%04d", ts.Year()),
+		"{mm}", fmt.Sprintf("%02d", ts.Month()),
+	).Replace(c.Index), nil
+}
+
+// templatePatterns returns the index patterns our template should apply to
+func (c *ElasticsearchConfig) templatePatterns() []string {
+	pattern := esPlaceholder.ReplaceAllString(c.Index, "*")
+	if c.rollover() && !strings.HasSuffix(pattern, "*") {
+		pattern += "-*" // the indices behind the write alias
+	}
+	return []string{pattern}
+}
+
+// policy returns the body of our ILM policy
+func (l *ESLifecycle) policy() map[string]interface{} {
+	phases := map[string]interface{}{}
+
+	if l.RolloverMaxAge != "" || l.RolloverMaxSize != "" {
+		rollover := map[string]string{}
+		if l.RolloverMaxAge != "" {
+			rollover["max_age"] = l.RolloverMaxAge
+		}
+		if l.RolloverMaxSize != "" {
+			rollover["max_size"] = l.RolloverMaxSize
+		}
+		phases["hot"] = map[string]interface{}{
+			"actions": map[string]interface{}{"rollover": rollover},
+		}
+	}
+	if l.DeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": l.DeleteAfter,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+
+	return map[string]interface{}{
+		"policy": map[string]interface{}{"phases": phases},
+	}
+}
+
+// esConfigHash summarises the parts of the config that end up in our index
+// template, so we can tell if the template needs updating.
+func esConfigHash(c *ElasticsearchConfig) string {
+	data, _ := json.Marshal([]interface{}{c.templatePatterns(), c.Alias, c.Lifecycle})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// esIndexPart makes a value safe to use as part of an index name
+func esIndexPart(s string) string {
+	s = strings.Trim(esUnsafeIndex.ReplaceAllString(strings.ToLower(s), "_"), "_-")
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+func TestElasticsearchConfigIndexName(t *testing.T) {
+	cfg := (&ElasticsearchConfig{Addresses: []string{"http://localhost:9200"}, Index: "beancounter-{bank}-{yyyy}"}).withDefaults()
+	assert.Nil(t, cfg.validate())
+
+	name, err := cfg.indexName(&domain.Transaction{Bank: "My Bank: UK", Timestamp: "2020-07-01T00:30:00+01:00"})
+	assert.Nil(t, err)
+	assert.Equal(t, "beancounter-my_bank_uk-2020", name)
+
+	assert.Equal(t, []string{"beancounter-*-*"}, cfg.templatePatterns())
+	assert.Equal(t, DefaultESAlias, cfg.Alias)
+}
+
+func TestElasticsearchConfigRollover(t *testing.T) {
+	cfg := (&ElasticsearchConfig{
+		Index:     "beancounter-{bank}",
+		Lifecycle: &ESLifecycle{Policy: "beancounter", RolloverMaxSize: "5gb"},
+	}).withDefaults()
+
+	assert.True(t, cfg.rollover())
+	assert.Equal(t, []string{"beancounter-*"}, cfg.templatePatterns())
+
+	// rolled over indices need to be told their write alias by a template
+	short, long := esRolloverTemplate(cfg, "beancounter-hsbc"), esRolloverTemplate(cfg, "beancounter-hsbc-uk")
+	assert.Equal(t, []string{"beancounter-hsbc-uk-*"}, long["index_patterns"])
+	assert.Equal(t, "beancounter-hsbc-uk", long["template"].(map[string]interface{})["settings"].(map[string]interface{})["index.lifecycle.rollover_alias"])
+	assert.True(t, long["priority"].(int) > short["priority"].(int))
+
+	cfg = (&ElasticsearchConfig{
+		Index:     "beancounter",
+		Lifecycle: &ESLifecycle{Policy: "beancounter", RolloverMaxAge: "365d"},
+	}).withDefaults()
+	assert.Equal(t, []string{"beancounter-*"}, cfg.templatePatterns())
+}
+
+func TestElasticsearchConfigValidate(t *testing.T) {
+	addr := []string{"http://localhost:9200"}
+
+	assert.Nil(t, (&ElasticsearchConfig{CloudID: "name:abc"}).withDefaults().validate())
+	assert.NotNil(t, (&ElasticsearchConfig{}).withDefaults().validate())
+	assert.NotNil(t, (&ElasticsearchConfig{Addresses: addr, Index: "{bank}-{yyyy}"}).withDefaults().validate())
+	assert.NotNil(t, (&ElasticsearchConfig{Addresses: addr, Index: "beancounter-{day}"}).withDefaults().validate())
+	assert.NotNil(t, (&ElasticsearchConfig{Addresses: addr, Index: "x", Alias: "x"}).withDefaults().validate())
+}
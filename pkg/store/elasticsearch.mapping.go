@@ -0,0 +1,116 @@
+package store
+
+// Index template & mappings for search stores.
+// https://www.elastic.co/guide/en/elasticsearch/reference/7.8/index-templates.html
+
+const (
+	// esMappingVersion is recorded in the template & each index mapping
+	// (_meta.version), bump it whenever esProperties changes so existing
+	// indices are upgraded.
+	esMappingVersion = 2
+)
+
+// esProperties are the explicit field mappings for a transaction
+func esProperties() map[string]interface{} {
+	keyword := map[string]interface{}{"type": "keyword"}
+
+	return map[string]interface{}{
+		"id":       keyword,
+		"bank":     keyword,
+		"account":  keyword,
+		"currency": keyword,
+		"timestamp": map[string]interface{}{
+			"type":   "date",
+			"format": "strict_date_optional_time||yyyy-MM-dd'T'HH:mm:ss||yyyy-MM-dd",
+		},
+		"description": map[string]interface{}{
+			"type": "text",
+			"fields": map[string]interface{}{
+				"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 512},
+			},
+		},
+		"amount": map[string]interface{}{
+			"type":           "scaled_float",
+			"scaling_factor": 10000,
+		},
+		"type":     keyword,
+		"category": keyword,
+		"merchant": keyword,
+		"tags":     keyword,
+
+		// version 2: the rest of the provider payload
+		"running_balance": map[string]interface{}{
+			"type":           "scaled_float",
+			"scaling_factor": 10000,
+		},
+		"counterparty": map[string]interface{}{
+			"type": "text",
+			"fields": map[string]interface{}{
+				"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 512},
+			},
+		},
+		"reference":              keyword,
+		"provider_id":            keyword,
+		"normalised_provider_id": keyword,
+		"extra":                  map[string]interface{}{"type": "object", "dynamic": true},
+	}
+}
+
+// esDynamicTemplates maps the provider specific fields under extra, which
+// we can't know in advance, as keywords
+func esDynamicTemplates() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"extra": map[string]interface{}{
+				"path_match": "extra.*",
+				"mapping":    map[string]interface{}{"type": "keyword", "ignore_above": 1024},
+			},
+		},
+	}
+}
+
+// esMappings is the mapping body for an index
+func esMappings() map[string]interface{} {
+	return map[string]interface{}{
+		"_meta":             map[string]interface{}{"version": esMappingVersion},
+		"dynamic_templates": esDynamicTemplates(),
+		"properties":        esProperties(),
+	}
+}
+
+// esIndexTemplate is the body of our (composable) index template, which
+// applies our mappings, alias & lifecycle policy to any index we create.
+func esIndexTemplate(cfg *ElasticsearchConfig) map[string]interface{} {
+	template := map[string]interface{}{
+		"mappings": esMappings(),
+		"aliases":  map[string]interface{}{cfg.Alias: map[string]interface{}{}},
+	}
+	if cfg.Lifecycle != nil {
+		template["settings"] = map[string]interface{}{
+			"index.lifecycle.name": cfg.Lifecycle.Policy,
+		}
+	}
+
+	return map[string]interface{}{
+		"index_patterns": cfg.templatePatterns(),
+		"version":        esMappingVersion,
+		"priority":       200,
+		"template":       template,
+		"_meta":          map[string]interface{}{"config": esConfigHash(cfg)},
+	}
+}
+
+// esRolloverTemplate is the body of the template for the indices behind a
+// write alias, which is our index template naming the alias to roll over.
+// It's given a higher priority than our index template (& templates of
+// write aliases that are a prefix of this one) so that it wins.
+func esRolloverTemplate(cfg *ElasticsearchConfig, writeAlias string) map[string]interface{} {
+	template := esIndexTemplate(cfg)
+	template["index_patterns"] = []string{writeAlias + "-*"}
+	template["priority"] = 200 + len(writeAlias)
+	template["template"].(map[string]interface{})["settings"] = map[string]interface{}{
+		"index.lifecycle.name":           cfg.Lifecycle.Policy,
+		"index.lifecycle.rollover_alias": writeAlias,
+	}
+	return template
+}
@@ -18,17 +18,18 @@ import (
 // from https://github.com/elastic/go-elasticsearch/blob/master/_examples/bulk/indexer.go
 
 const (
-	esIndex = "beancounter"
-	esFlush = 2048
-
 	envEsAddr = "ELASTICSEARCH_SERVICE_HOST"
 	envEsPort = "ELASTICSEARCH_SERVICE_PORT"
 )
 
+// check it meets the interfaces
+var _ Reader = &ElasticsearchV8{}
+
 type ElasticsearchV8 struct {
-	addresses []string
+	esCluster
 }
 
+// NewElasticsearchV8 returns a store using the default index & alias
 func NewElasticsearchV8(urls ...string) Store {
 	if len(urls) == 0 {
 		address := os.Getenv(envEsAddr)
@@ -42,14 +43,30 @@ func NewElasticsearchV8(urls ...string) Store {
 		urls = []string{fmt.Sprintf("http://%s:%s", address, port)}
 	}
 
-	return &ElasticsearchV8{addresses: urls}
+	return &ElasticsearchV8{esCluster{cfg: (&ElasticsearchConfig{Addresses: urls}).withDefaults()}}
 }
 
-func (e *ElasticsearchV8) Write(txns []*domain.Transaction) error {
+// NewElasticsearchV8WithConfig returns a store with the given config
+func NewElasticsearchV8WithConfig(cfg *ElasticsearchConfig) (Store, error) {
+	final := cfg.withDefaults()
+	err := final.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ElasticsearchV8{esCluster{cfg: final}}, nil
+}
+
+func (e *ElasticsearchV8) client() (*elasticsearch.Client, error) {
 	retryBackoff := backoff.NewExponentialBackOff()
 
-	es, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: e.addresses,
+	return elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: e.cfg.Addresses,
+		CloudID:   e.cfg.CloudID,
+		Username:  e.cfg.Username,
+		Password:  e.cfg.Password,
+		APIKey:    e.cfg.APIKey,
+		CACert:    e.cfg.CACert,
 
 		// Retry on 429 TooManyRequests statuses
 		RetryOnStatus: []int{502, 503, 504, 429},
@@ -65,24 +82,39 @@ func (e *ElasticsearchV8) Write(txns []*domain.Transaction) error {
 		// Retry up to 5 attempts
 		MaxRetries: 5,
 	})
+}
+
+func (e *ElasticsearchV8) Write(txns []*domain.Transaction) error {
+	es, err := e.client()
 	if err != nil {
 		return err
 	}
 
-	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Index:         esIndex,
-		FlushBytes:    esFlush,
-		Client:        es,
-		NumWorkers:    4,
-		FlushInterval: 10 * time.Second,
-	})
+	indices, targets, err := e.targets(txns)
+	if err != nil {
+		return err
+	}
+
+	err = e.setup(es, targets)
 	if err != nil {
 		return err
 	}
 
-	_, err = es.Indices.Create(esIndex)
+	failures := &bulkFailures{}
+	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		FlushBytes:    e.cfg.FlushBytes,
+		Client:        es,
+		NumWorkers:    e.cfg.Workers,
+		FlushInterval: e.cfg.FlushInterval,
+
+		// OnError is called for errors not tied to a document, eg. a
+		// whole bulk request failing
+		OnError: func(ctx context.Context, err error) {
+			failures.add(&BulkFailure{Type: "bulk", Reason: err.Error()})
+		},
+	})
 	if err != nil {
-		log.Println("attempted to make index", esIndex, err)
+		return err
 	}
 
 	for _, t := range txns {
@@ -97,6 +129,9 @@ func (e *ElasticsearchV8) Write(txns []*domain.Transaction) error {
 				// Action field configures the operation to perform (index, create, delete, update)
 				Action: "index",
 
+				// Index is the index (or write alias) to write to
+				Index: indices[t],
+
 				// DocumentID is the (optional) document ID
 				DocumentID: t.ID,
 
@@ -108,32 +143,53 @@ func (e *ElasticsearchV8) Write(txns []*domain.Transaction) error {
 
 				// OnFailure is called for each failed operation
 				OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-					if err != nil {
-						log.Printf("failed to index transactions: %s\n", err)
-					} else {
-						log.Printf("failed to index transactions %s: %s\n", res.Error.Type, res.Error.Reason)
-					}
+					failures.add(bulkItemFailure(
+						item.DocumentID, item.Index, res.Status,
+						res.Error.Type, res.Error.Reason,
+						res.Error.Cause.Type, res.Error.Cause.Reason,
+						err,
+					))
 				},
 			},
 		)
 
 		if err != nil {
+			bi.Close(context.Background())
 			return err
 		}
 	}
 
 	err = bi.Close(context.Background())
 	if err != nil {
-		return nil
+		return fmt.Errorf("failed to flush bulk indexer: %v", err)
 	}
 
 	biStats := bi.Stats()
+	err = failures.err(int64(biStats.NumIndexed))
+	if err != nil {
+		return err
+	}
 	if biStats.NumFailed > 0 {
-		log.Printf("Indexed [%d] documents with [%d] errors\n", int64(biStats.NumFlushed), int64(biStats.NumFailed))
+		// failures we weren't told the details of
 		return fmt.Errorf("failed indexing %d docs", int64(biStats.NumFailed))
-	} else {
-		log.Printf("Sucessfuly indexed [%d] documents\n", int64(biStats.NumFlushed))
 	}
 
+	log.Printf("Sucessfuly indexed [%d] documents\n", int64(biStats.NumFlushed))
 	return nil
 }
+
+func (e *ElasticsearchV8) Query(q *Query) ([]*domain.Transaction, error) {
+	es, err := e.client()
+	if err != nil {
+		return nil, err
+	}
+	return e.query(es, q)
+}
+
+func (e *ElasticsearchV8) Latest() ([]*domain.Transaction, error) {
+	es, err := e.client()
+	if err != nil {
+		return nil, err
+	}
+	return e.latest(es)
+}
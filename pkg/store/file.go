@@ -0,0 +1,55 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/voidshard/beancounter/pkg/fileutil"
+)
+
+// Cipher encrypts what a file store writes & decrypts it again on read,
+// eg. crypto.Passphrase, crypto.Age or crypto.PGP
+type Cipher interface {
+	Seal(plaintext []byte) ([]byte, error)
+	Open(sealed []byte) ([]byte, error)
+
+	// Sealed returns if data looks to have been sealed by the cipher, data
+	// that isn't is taken to be plaintext
+	Sealed(data []byte) bool
+}
+
+// Encryptable is implemented by file stores that can encrypt their files
+type Encryptable interface {
+	SetCipher(Cipher)
+}
+
+// readFile reads a store's file, decrypting it if it's been sealed. An
+// existing plaintext file is returned as is, so that it's encrypted the next
+// time it's written.
+func readFile(filename string, cipher Cipher) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil || cipher == nil || !cipher.Sealed(data) {
+		return data, err
+	}
+
+	data, err = cipher.Open(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %v", filename, err)
+	}
+	return data, nil
+}
+
+// writeFileSealed is fileutil.WriteAtomic, encrypting the data first if we've a
+// cipher. Encrypted files are only readable by us.
+func writeFileSealed(filename string, data []byte, perm os.FileMode, cipher Cipher) error {
+	if cipher == nil {
+		return fileutil.WriteAtomic(filename, data, perm)
+	}
+
+	sealed, err := cipher.Seal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %v", filename, err)
+	}
+	return fileutil.WriteAtomic(filename, sealed, 0600)
+}
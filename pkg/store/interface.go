@@ -1,9 +1,41 @@
 package store
 
 import (
+	"time"
+
 	"github.com/voidshard/beancounter/pkg/domain"
 )
 
 type Store interface {
 	Write([]*domain.Transaction) error
 }
+
+// Reader is implemented by stores that can tell us what they hold.
+type Reader interface {
+	// Query returns transactions matching the query, oldest first.
+	Query(*Query) ([]*domain.Transaction, error)
+
+	// Latest returns the most recent transaction for each bank account.
+	Latest() ([]*domain.Transaction, error)
+}
+
+// AccountSync is how far a connection has synced an account
+type AccountSync struct {
+	Connection string
+	Bank       string
+	Account    string
+
+	// SyncedFrom & SyncedTo are the window fetched & written
+	SyncedFrom time.Time
+	SyncedTo   time.Time
+
+	// HighWater is the time of the newest transaction written
+	HighWater time.Time
+}
+
+// SyncRecorder is implemented by stores that keep how far each account has
+// been synced alongside its transactions, so that it can be seen next to
+// them (eg. in a dashboard).
+type SyncRecorder interface {
+	RecordSync([]*AccountSync) error
+}
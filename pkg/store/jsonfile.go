@@ -3,21 +3,116 @@ package store
 import (
 	"encoding/json"
 	"github.com/voidshard/beancounter/pkg/domain"
-	"io/ioutil"
+	"os"
+	"sort"
 )
 
+// check it meets the interfaces
+var _ Reader = &JSONFile{}
+
 type JSONFile struct {
 	filename string
+	merge    bool
+	cipher   Cipher
 }
 
+// NewJSONFile returns a store that overwrites the file on each Write
 func NewJSONFile(filename string) Store {
 	return &JSONFile{filename: filename}
 }
 
+// NewJSONFileMerge returns a store that merges each Write into what's
+// already in the file, replacing transactions with the same bank, account
+// and ID. The file is kept sorted & indented so it diffs cleanly.
+func NewJSONFileMerge(filename string) Store {
+	return &JSONFile{filename: filename, merge: true}
+}
+
+// NewEncryptedJSONFile returns a JSON file store that encrypts the file with
+// the given cipher. An existing plaintext file is read as is (& encrypted
+// the next time it's written).
+func NewEncryptedJSONFile(filename string, merge bool, cipher Cipher) Store {
+	return &JSONFile{filename: filename, merge: merge, cipher: cipher}
+}
+
 func (f *JSONFile) Write(txns []*domain.Transaction) error {
-	data, err := json.Marshal(txns)
+	if !f.merge {
+		data, err := json.Marshal(txns)
+		if err != nil {
+			return err
+		}
+		return f.write(data)
+	}
+
+	existing, err := f.read()
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(f.filename, data, 0644)
+
+	merged := map[[3]string]*domain.Transaction{}
+	for _, t := range existing {
+		merged[[3]string{t.Bank, t.Account, t.ID}] = t
+	}
+	for _, t := range txns {
+		merged[[3]string{t.Bank, t.Account, t.ID}] = t
+	}
+
+	all := []*domain.Transaction{}
+	for _, t := range merged {
+		all = append(all, t)
+	}
+	sortTransactions(all)
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Bank != all[j].Bank {
+			return all[i].Bank < all[j].Bank
+		}
+		return all[i].Account < all[j].Account
+	})
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return f.write(data)
+}
+
+// SetCipher has the file encrypted
+func (f *JSONFile) SetCipher(cipher Cipher) {
+	f.cipher = cipher
+}
+
+// write replaces the file with data, encrypted if we have a cipher
+func (f *JSONFile) write(data []byte) error {
+	return writeFileSealed(f.filename, data, 0644, f.cipher)
+}
+
+func (f *JSONFile) Query(q *Query) ([]*domain.Transaction, error) {
+	txns, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return filterTransactions(q, txns), nil
+}
+
+func (f *JSONFile) Latest() ([]*domain.Transaction, error) {
+	txns, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return latestTransactions(txns), nil
+}
+
+// read loads all transactions from the file, if it exists.
+func (f *JSONFile) read() ([]*domain.Transaction, error) {
+	txns := []*domain.Transaction{}
+
+	data, err := readFile(f.filename, f.cipher)
+	if os.IsNotExist(err) {
+		return txns, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &txns)
+	return txns, err
 }
@@ -1,9 +1,14 @@
 package store
 
 import (
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/crypto"
 	"github.com/voidshard/beancounter/pkg/domain"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestWrite(t *testing.T) {
@@ -16,3 +21,101 @@ func TestWrite(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func TestJSONFileQuery(t *testing.T) {
+	jf := NewJSONFile("/tmp/test_query.json")
+
+	err := jf.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "3", Bank: "a", Account: "x", Timestamp: "2020-07-03T00:00:00Z", Description: "Coffee Shop"},
+		&domain.Transaction{ID: "1", Bank: "a", Account: "x", Timestamp: "2020-07-01T00:00:00Z", Merchant: "COFFEE CO", Category: "PURCHASE"},
+		&domain.Transaction{ID: "2", Bank: "a", Account: "y", Timestamp: "2020-07-02T00:00:00Z", Description: "Rent"},
+	})
+	assert.Nil(t, err)
+
+	reader := jf.(Reader)
+
+	found, err := reader.Query(&Query{Text: "coffee"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(found))
+	assert.Equal(t, "1", found[0].ID)
+	assert.Equal(t, "3", found[1].ID)
+
+	found, err = reader.Query(&Query{
+		Account: "x",
+		From:    time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC),
+		To:      time.Date(2020, 7, 4, 0, 0, 0, 0, time.UTC),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "3", found[0].ID)
+
+	found, err = reader.Query(&Query{Category: "PURCHASE"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found))
+
+	latest, err := reader.Latest()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(latest))
+	assert.Equal(t, "3", latest[0].ID)
+	assert.Equal(t, "2", latest[1].ID)
+}
+
+func TestJSONFileMerge(t *testing.T) {
+	filename := "/tmp/test_merge.json"
+	os.Remove(filename)
+	defer os.Remove(filename)
+
+	jf := NewJSONFileMerge(filename)
+
+	assert.Nil(t, jf.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "2", Bank: "a", Account: "x", Timestamp: "2020-07-02T00:00:00Z"},
+		&domain.Transaction{ID: "1", Bank: "a", Account: "x", Timestamp: "2020-07-01T00:00:00Z"},
+	}))
+	assert.Nil(t, jf.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "1", Bank: "a", Account: "x", Timestamp: "2020-07-01T00:00:00Z", Amount: 5},
+		&domain.Transaction{ID: "1", Bank: "b", Account: "x", Timestamp: "2020-06-01T00:00:00Z"},
+	}))
+
+	found, err := jf.(Reader).Query(&Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(found))
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+
+	written := []*domain.Transaction{}
+	assert.Nil(t, json.Unmarshal(data, &written))
+	assert.Equal(t, "a", written[0].Bank)
+	assert.Equal(t, "1", written[0].ID)
+	assert.Equal(t, 5.0, written[0].Amount)
+	assert.Equal(t, "2", written[1].ID)
+	assert.Equal(t, "b", written[2].Bank)
+}
+
+func TestEncryptedJSONFile(t *testing.T) {
+	filename := "/tmp/test_encrypted.json"
+	os.Remove(filename)
+	defer os.Remove(filename)
+
+	// an existing plaintext file is taken up & encrypted
+	assert.Nil(t, NewJSONFile(filename).Write([]*domain.Transaction{
+		&domain.Transaction{ID: "1", Bank: "a", Account: "x", Timestamp: "2020-07-01T00:00:00Z"},
+	}))
+
+	jf := NewEncryptedJSONFile(filename, true, crypto.Passphrase("pass"))
+	assert.Nil(t, jf.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "2", Bank: "a", Account: "x", Timestamp: "2020-07-02T00:00:00Z", Description: "Rent"},
+	}))
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.True(t, crypto.IsSealed(data))
+	assert.NotContains(t, string(data), "Rent")
+
+	found, err := jf.(Reader).Query(&Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(found))
+
+	_, err = NewEncryptedJSONFile(filename, false, crypto.Passphrase("wrong")).(Reader).Latest()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+const (
+	// DefaultLedgerAsset is the account transactions are posted against
+	DefaultLedgerAsset = "assets:bank:{bank}:{account}"
+
+	// DefaultLedgerExpense is the balancing account for money going out
+	DefaultLedgerExpense = "expenses:{category}"
+
+	// DefaultLedgerIncome is the balancing account for money coming in
+	DefaultLedgerIncome = "income:{category}"
+
+	ledgerUnknown = "unknown"
+)
+
+var (
+	// matches the metadata comment we write under each transaction,
+	// understood by both hledger (as a tag) and ledger-cli (as metadata)
+	ledgerIDLine = regexp.MustCompile(`^\s+;\s*id:\s*(\S+)`)
+
+	ledgerSpaces = regexp.MustCompile(`\s+`)
+
+	// characters we don't allow in metadata names
+	ledgerUnsafeTag = regexp.MustCompile(`[^a-z0-9_-]+`)
+)
+
+// LedgerAccounts are templates used to name accounts in the journal.
+// Templates may include {bank}, {account}, {currency}, {category} and {type}.
+type LedgerAccounts struct {
+	Asset   string
+	Expense string
+	Income  string
+}
+
+// Ledger writes a ledger-cli / hledger journal. Each transaction records
+// its ID as metadata so re-runs only append transactions we haven't
+// written before.
+type Ledger struct {
+	filename string
+	accounts *LedgerAccounts
+	cipher   Cipher
+}
+
+func NewLedger(filename string, accounts *LedgerAccounts) Store {
+	named := &LedgerAccounts{
+		Asset:   DefaultLedgerAsset,
+		Expense: DefaultLedgerExpense,
+		Income:  DefaultLedgerIncome,
+	}
+	if accounts != nil {
+		if accounts.Asset != "" {
+			named.Asset = accounts.Asset
+		}
+		if accounts.Expense != "" {
+			named.Expense = accounts.Expense
+		}
+		if accounts.Income != "" {
+			named.Income = accounts.Income
+		}
+	}
+	return &Ledger{filename: filename, accounts: named}
+}
+
+func (l *Ledger) Write(txns []*domain.Transaction) error {
+	seen, err := l.existingIDs()
+	if err != nil {
+		return err
+	}
+
+	todo := []*domain.Transaction{}
+	for _, t := range txns {
+		if seen[t.ID] {
+			continue
+		}
+		seen[t.ID] = true
+		todo = append(todo, t)
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+
+	// journals are easiest to read (and diff) in date order
+	sort.SliceStable(todo, func(i, j int) bool {
+		if todo[i].Timestamp == todo[j].Timestamp {
+			return todo[i].ID < todo[j].ID
+		}
+		return todo[i].Timestamp < todo[j].Timestamp
+	})
+
+	if l.cipher != nil {
+		// no appending in place, the journal is rewritten
+		journal, err := readFile(l.filename, l.cipher)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		buf := bytes.NewBuffer(journal)
+		err = l.writeEntries(buf, todo)
+		if err != nil {
+			return err
+		}
+		return writeFileSealed(l.filename, buf.Bytes(), 0644, l.cipher)
+	}
+
+	f, err := os.OpenFile(l.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = l.writeEntries(f, todo)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SetCipher has the journal encrypted
+func (l *Ledger) SetCipher(cipher Cipher) {
+	l.cipher = cipher
+}
+
+// writeEntries writes transactions to w as journal entries
+func (l *Ledger) writeEntries(w io.Writer, txns []*domain.Transaction) error {
+	buf := bufio.NewWriter(w)
+	for _, t := range txns {
+		entry, err := l.entry(t)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(entry)
+	}
+	return buf.Flush()
+}
+
+// existingIDs reads the IDs of transactions already in the journal, if any.
+func (l *Ledger) existingIDs() (map[string]bool, error) {
+	seen := map[string]bool{}
+
+	var r io.Reader
+	if l.cipher != nil {
+		journal, err := readFile(l.filename, l.cipher)
+		if os.IsNotExist(err) {
+			return seen, nil
+		} else if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(journal)
+	} else {
+		f, err := os.Open(l.filename)
+		if os.IsNotExist(err) {
+			return seen, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		match := ledgerIDLine.FindStringSubmatch(scanner.Text())
+		if len(match) == 2 {
+			seen[match[1]] = true
+		}
+	}
+
+	return seen, scanner.Err()
+}
+
+// entry renders a single transaction as a journal entry
+func (l *Ledger) entry(t *domain.Transaction) (string, error) {
+	ts, err := t.Time()
+	if err != nil {
+		return "", err
+	}
+
+	contra := l.accounts.Expense
+	if t.Amount > 0 {
+		contra = l.accounts.Income
+	}
+
+	return fmt.Sprintf(
+		"\n%s %s\n    ; id: %s\n%s    %s  %s %s\n    %s\n",
+		ts.Format("2006-01-02"),
+		ledgerDescription(t),
+		t.ID,
+		ledgerMetadata(t),
+		l.accountName(l.accounts.Asset, t),
+		strconv.FormatFloat(t.Amount, 'f', 2, 64),
+		t.Currency,
+		l.accountName(contra, t),
+	), nil
+}
+
+// ledgerMetadata renders the provider's extra details as metadata comments
+// (beneath the id), one per line.
+func ledgerMetadata(t *domain.Transaction) string {
+	lines := []string{}
+	add := func(name, value string) {
+		value = strings.TrimSpace(ledgerSpaces.ReplaceAllString(value, " "))
+		if value == "" {
+			return
+		}
+		lines = append(lines, fmt.Sprintf("    ; %s: %s\n", name, value))
+	}
+
+	add("counterparty", t.Counterparty)
+	add("reference", t.Reference)
+	add("provider_id", t.ProviderID)
+	add("normalised_provider_id", t.NormalisedProviderID)
+	if t.RunningBalance != nil {
+		add("balance", strconv.FormatFloat(*t.RunningBalance, 'f', 2, 64)+" "+t.Currency)
+	}
+
+	names := []string{}
+	for name := range t.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tag := strings.Trim(ledgerUnsafeTag.ReplaceAllString(strings.ToLower(name), "_"), "_")
+		if tag == "" || tag == "id" {
+			continue // the id line is how we recognise what we've written
+		}
+		add(tag, t.Extra[name])
+	}
+
+	return strings.Join(lines, "")
+}
+
+// accountName fills in the given template from the transaction.
+func (l *Ledger) accountName(template string, t *domain.Transaction) string {
+	category := strings.ToLower(t.Category)
+	if category == "" {
+		category = ledgerUnknown
+	}
+
+	return strings.NewReplacer(
+		"{bank}", ledgerAccountPart(t.Bank),
+		"{account}", ledgerAccountPart(t.Account),
+		"{currency}", ledgerAccountPart(t.Currency),
+		"{category}", ledgerAccountPart(category),
+		"{type}", ledgerAccountPart(strings.ToLower(t.Type)),
+	).Replace(template)
+}
+
+// ledgerAccountPart makes a value safe to use as part of an account name.
+// Colons would create sub accounts & two spaces end the name.
+func ledgerAccountPart(s string) string {
+	s = strings.TrimSpace(ledgerSpaces.ReplaceAllString(s, " "))
+	s = strings.Replace(s, ":", "-", -1)
+	if s == "" {
+		return ledgerUnknown
+	}
+	return s
+}
+
+// ledgerDescription makes the description safe for the header line,
+// where a ';' would start a comment.
+func ledgerDescription(t *domain.Transaction) string {
+	desc := t.Description
+	if desc == "" {
+		desc = t.Merchant
+	}
+	desc = strings.Replace(desc, ";", ",", -1)
+	return strings.TrimSpace(ledgerSpaces.ReplaceAllString(desc, " "))
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/crypto"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+func TestLedgerWriteIsIdempotent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.journal")
+	lg := NewLedger(filename, &LedgerAccounts{Asset: "assets:{bank}:{account}"})
+
+	first := &domain.Transaction{
+		ID:          "1",
+		Bank:        "My Bank",
+		Account:     "Current: Joint",
+		Currency:    "GBP",
+		Timestamp:   "2020-07-01T00:00:00+00:00",
+		Description: "COFFEE; SHOP",
+		Amount:      -3.5,
+		Category:    "PURCHASE",
+	}
+	second := &domain.Transaction{
+		ID:          "2",
+		Bank:        "My Bank",
+		Account:     "Current: Joint",
+		Currency:    "GBP",
+		Timestamp:   "2020-07-02T00:00:00+00:00",
+		Description: "SALARY",
+		Amount:      1000,
+	}
+
+	assert.Nil(t, lg.Write([]*domain.Transaction{first}))
+	assert.Nil(t, lg.Write([]*domain.Transaction{first, second}))
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	journal := string(data)
+
+	assert.Equal(t, 1, strings.Count(journal, "; id: 1\n"))
+	assert.Equal(t, 1, strings.Count(journal, "; id: 2\n"))
+	assert.Contains(t, journal, "2020-07-01 COFFEE, SHOP\n")
+	assert.Contains(t, journal, "assets:My Bank:Current- Joint  -3.50 GBP\n")
+	assert.Contains(t, journal, "    expenses:purchase\n")
+	assert.Contains(t, journal, "    income:unknown\n")
+}
+
+func TestLedgerMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.journal")
+	lg := NewLedger(filename, nil)
+
+	balance := 1238.6
+	assert.Nil(t, lg.Write([]*domain.Transaction{{
+		ID:             "1",
+		Bank:           "bank",
+		Account:        "acc",
+		Currency:       "GBP",
+		Timestamp:      "2020-07-01",
+		Description:    "GOOGLE PLAY",
+		Amount:         -2.99,
+		RunningBalance: &balance,
+		Counterparty:   "Google  Play",
+		Reference:      "REF 1",
+		Extra:          map[string]string{"cardNumber": "1234", "id": "clash"},
+	}}))
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, `
+2020-07-01 GOOGLE PLAY
+    ; id: 1
+    ; counterparty: Google Play
+    ; reference: REF 1
+    ; balance: 1238.60 GBP
+    ; cardnumber: 1234
+    assets:bank:bank:acc  -2.99 GBP
+    expenses:unknown
+`, string(data))
+}
+
+func TestLedgerEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	id, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+	cipher, err := crypto.NewAge([]string{id.Recipient().String()}, id.String())
+	assert.Nil(t, err)
+
+	filename := filepath.Join(dir, "test.journal.age")
+	lg := NewLedger(filename, nil)
+	lg.(Encryptable).SetCipher(cipher)
+
+	txn := &domain.Transaction{ID: "1", Bank: "b", Account: "a", Timestamp: "2020-07-01T00:00:00Z", Description: "Rent", Amount: -500}
+	assert.Nil(t, lg.Write([]*domain.Transaction{txn}))
+	assert.Nil(t, lg.Write([]*domain.Transaction{txn, {ID: "2", Bank: "b", Account: "a", Timestamp: "2020-07-02T00:00:00Z", Description: "Pay", Amount: 1000}}))
+
+	sealed, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(sealed), "Rent")
+
+	journal, err := cipher.Open(sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(journal), "Rent"))
+	assert.Equal(t, 1, strings.Count(string(journal), "Pay"))
+}
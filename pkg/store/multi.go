@@ -0,0 +1,114 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+// StoreError is the error a single store (of a Multi) returned
+type StoreError struct {
+	Name string
+	Err  error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+// MultiError is returned when one or more stores of a Multi fail to write.
+// Stores not listed wrote successfully.
+type MultiError struct {
+	Written  []string
+	Failures []*StoreError
+}
+
+func (e *MultiError) Error() string {
+	lines := []string{
+		fmt.Sprintf("failed to write to %d of %d stores", len(e.Failures), len(e.Failures)+len(e.Written)),
+	}
+	for _, f := range e.Failures {
+		lines = append(lines, f.Error())
+	}
+	return strings.Join(lines, "\n\t")
+}
+
+// Multi writes transactions to several stores, eg. a file to keep as a
+// backup & a database for dashboards. Every store is written to, even if
+// others fail.
+type Multi struct {
+	names  []string
+	stores []Store
+}
+
+func NewMulti() *Multi {
+	return &Multi{}
+}
+
+// Add a store, the name is used when reporting errors
+func (m *Multi) Add(name string, s Store) {
+	m.names = append(m.names, name)
+	m.stores = append(m.stores, s)
+}
+
+// RecordSync records sync state in those of our stores that keep it,
+// returning a *MultiError if any fail
+func (m *Multi) RecordSync(syncs []*AccountSync) error {
+	result := &MultiError{}
+	for i, s := range m.stores {
+		recorder, ok := s.(SyncRecorder)
+		if !ok {
+			continue
+		}
+		err := recorder.RecordSync(syncs)
+		if err != nil {
+			result.Failures = append(result.Failures, &StoreError{Name: m.names[i], Err: err})
+		} else {
+			result.Written = append(result.Written, m.names[i])
+		}
+	}
+	if len(result.Failures) == 0 {
+		return nil
+	}
+	return result
+}
+
+// Write writes to all stores at once, returning a *MultiError if any fail
+func (m *Multi) Write(txns []*domain.Transaction) error {
+	errs := make([]error, len(m.stores))
+
+	var wg sync.WaitGroup
+	for i, s := range m.stores {
+		// each store gets its own slice, so none can reorder another's
+		mine := make([]*domain.Transaction, len(txns))
+		copy(mine, txns)
+
+		wg.Add(1)
+		go func(i int, s Store) {
+			defer wg.Done()
+			defer func() {
+				// a misbehaving store shouldn't take the others down
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			errs[i] = s.Write(mine)
+		}(i, s)
+	}
+	wg.Wait()
+
+	result := &MultiError{}
+	for i, err := range errs {
+		if err == nil {
+			result.Written = append(result.Written, m.names[i])
+			continue
+		}
+		result.Failures = append(result.Failures, &StoreError{Name: m.names[i], Err: err})
+	}
+	if len(result.Failures) == 0 {
+		return nil
+	}
+	return result
+}
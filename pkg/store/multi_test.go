@@ -0,0 +1,45 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+type failingStore struct{}
+
+func (f *failingStore) Write(txns []*domain.Transaction) error {
+	return fmt.Errorf("unreachable")
+}
+
+func TestMultiWritesDespiteFailures(t *testing.T) {
+	dir := t.TempDir()
+	first := NewJSONFile(filepath.Join(dir, "first.json"))
+	second := NewJSONFile(filepath.Join(dir, "second.json"))
+
+	multi := NewMulti()
+	multi.Add("first", first)
+	multi.Add("broken", &failingStore{})
+	multi.Add("second", second)
+
+	txns := []*domain.Transaction{
+		{ID: "1", Bank: "b", Account: "a", Timestamp: "2020-07-01T00:00:00Z", Amount: 1},
+	}
+	err := multi.Write(txns)
+
+	merr, ok := err.(*MultiError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"first", "second"}, merr.Written)
+	assert.Len(t, merr.Failures, 1)
+	assert.Equal(t, "broken", merr.Failures[0].Name)
+	assert.Contains(t, err.Error(), "broken: unreachable")
+
+	for _, s := range []Store{first, second} {
+		found, err := s.(Reader).Query(&Query{})
+		assert.Nil(t, err)
+		assert.Len(t, found, 1)
+	}
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/fileutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+
+	// ndjsonBuffer is the size of our write buffer
+	ndjsonBuffer = 64 * 1024
+)
+
+// cipherExts are extensions usually given to encrypted files, which go
+// after the compression extension as we compress then encrypt
+var cipherExts = map[string]bool{".age": true, ".gpg": true, ".pgp": true, ".asc": true, ".enc": true}
+
+// check it meets the interfaces
+var _ Reader = &NDJSON{}
+
+// NDJSON writes newline delimited JSON, one transaction per line, streaming
+// rather than building the whole document in memory.
+type NDJSON struct {
+	filename    string
+	appendTo    bool
+	compression string
+	cipher      Cipher
+}
+
+// NewNDJSON returns a store writing to filename. If appendTo is set new
+// transactions are added to the end of the file, otherwise it's replaced.
+// Compression is one of none, gzip or zstd, if not given it's guessed from
+// the file extension (.gz, .zst), looking past an encryption extension (so
+// out.ndjson.gz.age is gzip).
+func NewNDJSON(filename string, appendTo bool, compression string) (Store, error) {
+	if compression == "" {
+		ext := strings.ToLower(filepath.Ext(filename))
+		if cipherExts[ext] {
+			ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(filename, filepath.Ext(filename))))
+		}
+		switch ext {
+		case ".gz", ".gzip":
+			compression = CompressGzip
+		case ".zst", ".zstd":
+			compression = CompressZstd
+		default:
+			compression = CompressNone
+		}
+	}
+
+	switch compression {
+	case CompressNone, CompressGzip, CompressZstd:
+	default:
+		return nil, fmt.Errorf("unknown compression %q, expected one of none, gzip, zstd", compression)
+	}
+
+	return &NDJSON{filename: filename, appendTo: appendTo, compression: compression}, nil
+}
+
+// SetCipher has the file encrypted (after compression). Encrypted files
+// can't be appended to in place, so appending rewrites the whole file.
+func (n *NDJSON) SetCipher(cipher Cipher) {
+	n.cipher = cipher
+}
+
+func (n *NDJSON) Write(txns []*domain.Transaction) error {
+	if n.cipher != nil {
+		buf := &bytes.Buffer{}
+		if n.appendTo {
+			existing, err := readFile(n.filename, n.cipher)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			buf.Write(existing)
+		}
+		err := n.stream(buf, txns)
+		if err != nil {
+			return err
+		}
+		return writeFileSealed(n.filename, buf.Bytes(), 0644, n.cipher)
+	}
+
+	if n.appendTo {
+		f, err := os.OpenFile(n.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		err = n.stream(f, txns)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	return fileutil.StreamAtomic(n.filename, 0644, func(w io.Writer) error {
+		return n.stream(w, txns)
+	})
+}
+
+// stream writes transactions to w, one per line. Compressed output is
+// written as a new gzip member / zstd frame which readers treat as one
+// continuous stream, so appending to a compressed file is fine.
+func (n *NDJSON) stream(w io.Writer, txns []*domain.Transaction) error {
+	var compressor io.WriteCloser
+	switch n.compression {
+	case CompressGzip:
+		compressor = gzip.NewWriter(w)
+	case CompressZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		compressor = zw
+	}
+	if compressor != nil {
+		w = compressor
+	}
+
+	buf := bufio.NewWriterSize(w, ndjsonBuffer)
+	enc := json.NewEncoder(buf) // Encode adds the trailing newline for us
+	for _, t := range txns {
+		err := enc.Encode(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := buf.Flush()
+	if err != nil {
+		return err
+	}
+	if compressor != nil {
+		return compressor.Close()
+	}
+	return nil
+}
+
+func (n *NDJSON) Query(q *Query) ([]*domain.Transaction, error) {
+	txns, err := n.read()
+	if err != nil {
+		return nil, err
+	}
+	return filterTransactions(q, txns), nil
+}
+
+func (n *NDJSON) Latest() ([]*domain.Transaction, error) {
+	txns, err := n.read()
+	if err != nil {
+		return nil, err
+	}
+	return latestTransactions(txns), nil
+}
+
+// read loads all transactions from the file, if it exists.
+func (n *NDJSON) read() ([]*domain.Transaction, error) {
+	txns := []*domain.Transaction{}
+
+	var r io.Reader
+	if n.cipher != nil {
+		data, err := readFile(n.filename, n.cipher)
+		if os.IsNotExist(err) {
+			return txns, nil
+		} else if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	} else {
+		f, err := os.Open(n.filename)
+		if os.IsNotExist(err) {
+			return txns, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	switch n.compression {
+	case CompressGzip:
+		gr, err := gzip.NewReader(r)
+		if err == io.EOF {
+			return txns, nil // empty file
+		} else if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case CompressZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	dec := json.NewDecoder(bufio.NewReaderSize(r, ndjsonBuffer))
+	for {
+		t := &domain.Transaction{}
+		err := dec.Decode(t)
+		if err == io.EOF {
+			return txns, nil
+		} else if err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
+	}
+}
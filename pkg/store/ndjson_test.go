@@ -0,0 +1,93 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/crypto"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+func TestNDJSONAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"test.ndjson", "test.ndjson.gz", "test.ndjson.zst"} {
+		nd, err := NewNDJSON(filepath.Join(dir, name), true, "")
+		assert.Nil(t, err)
+
+		assert.Nil(t, nd.Write([]*domain.Transaction{
+			&domain.Transaction{ID: "1", Timestamp: "2020-07-01T00:00:00Z"},
+		}))
+		assert.Nil(t, nd.Write([]*domain.Transaction{
+			&domain.Transaction{ID: "2", Timestamp: "2020-07-02T00:00:00Z"},
+			&domain.Transaction{ID: "3", Timestamp: "2020-07-03T00:00:00Z"},
+		}))
+
+		found, err := nd.(Reader).Query(&Query{})
+		assert.Nil(t, err, name)
+		assert.Equal(t, 3, len(found), name)
+	}
+}
+
+func TestNDJSONOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.ndjson")
+	nd, err := NewNDJSON(filename, false, CompressNone)
+	assert.Nil(t, err)
+
+	assert.Nil(t, nd.Write([]*domain.Transaction{&domain.Transaction{ID: "1"}}))
+	assert.Nil(t, nd.Write([]*domain.Transaction{&domain.Transaction{ID: "2"}}))
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.True(t, strings.HasPrefix(string(data), `{"id":"2",`))
+}
+
+func TestNDJSONEncryptedAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	id, err := age.GenerateX25519Identity()
+	assert.Nil(t, err)
+	cipher, err := crypto.NewAge([]string{id.Recipient().String()}, id.String())
+	assert.Nil(t, err)
+
+	for _, name := range []string{"test.ndjson.age", "test.ndjson.gz.age", "test.ndjson.zst.age"} {
+		nd, err := NewNDJSON(filepath.Join(dir, name), true, "")
+		assert.Nil(t, err)
+		if strings.Contains(name, ".gz") {
+			assert.Equal(t, CompressGzip, nd.(*NDJSON).compression)
+		} else if strings.Contains(name, ".zst") {
+			assert.Equal(t, CompressZstd, nd.(*NDJSON).compression)
+		}
+		nd.(Encryptable).SetCipher(cipher)
+
+		assert.Nil(t, nd.Write([]*domain.Transaction{
+			&domain.Transaction{ID: "1", Timestamp: "2020-07-01T00:00:00Z", Description: "Rent"},
+		}))
+		assert.Nil(t, nd.Write([]*domain.Transaction{
+			&domain.Transaction{ID: "2", Timestamp: "2020-07-02T00:00:00Z"},
+		}))
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		assert.True(t, cipher.Sealed(data), name)
+		assert.NotContains(t, string(data), "Rent")
+
+		found, err := nd.(Reader).Query(&Query{})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(found), name)
+	}
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"github.com/opensearch-project/opensearch-go/v2/opensearchutil"
+)
+
+// check it meets the interfaces
+var _ Reader = &OpenSearch{}
+
+// OpenSearch writes to an OpenSearch cluster. It shares the mappings,
+// index naming, setup & dedupe (by transaction ID) of ElasticsearchV8.
+type OpenSearch struct {
+	esCluster
+}
+
+// NewOpenSearch returns a store using the default index & alias
+func NewOpenSearch(urls ...string) Store {
+	if len(urls) == 0 {
+		urls = []string{"http://localhost:9200"}
+	}
+	return &OpenSearch{esCluster{cfg: (&ElasticsearchConfig{Addresses: urls}).withDefaults()}}
+}
+
+// NewOpenSearchWithConfig returns a store with the given config. OpenSearch
+// has no Elastic Cloud IDs, API keys or ILM (it has ISM instead), so these
+// aren't supported.
+func NewOpenSearchWithConfig(cfg *ElasticsearchConfig) (Store, error) {
+	final := cfg.withDefaults()
+	err := final.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case final.CloudID != "":
+		return nil, fmt.Errorf("opensearch does not support cloud ids")
+	case final.APIKey != "":
+		return nil, fmt.Errorf("opensearch does not support api keys, use a username & password")
+	case final.Lifecycle != nil:
+		return nil, fmt.Errorf("opensearch does not support ILM policies, manage index state (ISM) in opensearch instead")
+	}
+
+	return &OpenSearch{esCluster{cfg: final}}, nil
+}
+
+func (o *OpenSearch) client() (*opensearch.Client, error) {
+	retryBackoff := backoff.NewExponentialBackOff()
+
+	return opensearch.NewClient(opensearch.Config{
+		Addresses: o.cfg.Addresses,
+		Username:  o.cfg.Username,
+		Password:  o.cfg.Password,
+		CACert:    o.cfg.CACert,
+
+		// Retry on 429 TooManyRequests statuses
+		RetryOnStatus: []int{502, 503, 504, 429},
+
+		RetryBackoff: func(i int) time.Duration {
+			if i == 1 {
+				retryBackoff.Reset()
+			}
+			return retryBackoff.NextBackOff()
+		},
+
+		MaxRetries: 5,
+	})
+}
+
+func (o *OpenSearch) Write(txns []*domain.Transaction) error {
+	client, err := o.client()
+	if err != nil {
+		return err
+	}
+
+	indices, targets, err := o.targets(txns)
+	if err != nil {
+		return err
+	}
+
+	err = o.setup(client, targets)
+	if err != nil {
+		return err
+	}
+
+	failures := &bulkFailures{}
+	bi, err := opensearchutil.NewBulkIndexer(opensearchutil.BulkIndexerConfig{
+		FlushBytes:    o.cfg.FlushBytes,
+		Client:        client,
+		NumWorkers:    o.cfg.Workers,
+		FlushInterval: o.cfg.FlushInterval,
+
+		OnError: func(ctx context.Context, err error) {
+			failures.add(&BulkFailure{Type: "bulk", Reason: err.Error()})
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range txns {
+		data, err := t.JSON()
+		if err != nil {
+			return err
+		}
+
+		err = bi.Add(
+			context.Background(),
+			opensearchutil.BulkIndexerItem{
+				Action:     "index",
+				Index:      indices[t],
+				DocumentID: t.ID,
+				Body:       bytes.NewReader(data),
+				OnFailure: func(ctx context.Context, item opensearchutil.BulkIndexerItem, res opensearchutil.BulkIndexerResponseItem, err error) {
+					failures.add(bulkItemFailure(
+						item.DocumentID, item.Index, res.Status,
+						res.Error.Type, res.Error.Reason,
+						res.Error.Cause.Type, res.Error.Cause.Reason,
+						err,
+					))
+				},
+			},
+		)
+
+		if err != nil {
+			bi.Close(context.Background())
+			return err
+		}
+	}
+
+	err = bi.Close(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to flush bulk indexer: %v", err)
+	}
+
+	biStats := bi.Stats()
+	err = failures.err(int64(biStats.NumIndexed))
+	if err != nil {
+		return err
+	}
+	if biStats.NumFailed > 0 {
+		return fmt.Errorf("failed indexing %d docs", int64(biStats.NumFailed))
+	}
+
+	log.Printf("Sucessfuly indexed [%d] documents\n", int64(biStats.NumFlushed))
+	return nil
+}
+
+func (o *OpenSearch) Query(q *Query) ([]*domain.Transaction, error) {
+	client, err := o.client()
+	if err != nil {
+		return nil, err
+	}
+	return o.query(client, q)
+}
+
+func (o *OpenSearch) Latest() ([]*domain.Transaction, error) {
+	client, err := o.client()
+	if err != nil {
+		return nil, err
+	}
+	return o.latest(client)
+}
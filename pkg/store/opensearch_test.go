@@ -0,0 +1,24 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenSearchConfig(t *testing.T) {
+	addresses := []string{"https://localhost:9200"}
+
+	_, err := NewOpenSearchWithConfig(&ElasticsearchConfig{Addresses: addresses, Username: "admin", Password: "admin"})
+	assert.Nil(t, err)
+
+	for _, cfg := range []*ElasticsearchConfig{
+		{CloudID: "name:abc"},
+		{Addresses: addresses, APIKey: "key"},
+		{Addresses: addresses, Lifecycle: &ESLifecycle{Policy: "beancounter"}},
+		{},
+	} {
+		_, err = NewOpenSearchWithConfig(cfg)
+		assert.NotNil(t, err)
+	}
+}
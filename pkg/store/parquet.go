@@ -0,0 +1,179 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/fileutil"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// parquetScale is the number of decimal places amounts are stored with
+	parquetScale = 4
+
+	parquetFilename = "transactions.parquet"
+)
+
+// parquetTransaction is the typed schema of our parquet files. The bank,
+// year and month are encoded in the directory (hive partitioning) so
+// aren't repeated in the file.
+type parquetTransaction struct {
+	ID          string    `parquet:"id"`
+	Account     string    `parquet:"account,dict"`
+	Currency    string    `parquet:"currency,dict"`
+	Timestamp   time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	Description string    `parquet:"description"`
+	Amount      int64     `parquet:"amount,decimal(4:18)"`
+	Type        string    `parquet:"type,dict"`
+	Category    string    `parquet:"category,dict"`
+	Merchant    string    `parquet:"merchant"`
+	Tags        []string  `parquet:"tags,list"`
+
+	// added later, files written before these were added read them as empty
+	RunningBalance       *int64            `parquet:"running_balance,optional,decimal(4:18)"`
+	Counterparty         string            `parquet:"counterparty"`
+	Reference            string            `parquet:"reference"`
+	ProviderID           string            `parquet:"provider_id"`
+	NormalisedProviderID string            `parquet:"normalised_provider_id"`
+	Extra                map[string]string `parquet:"extra"`
+}
+
+// Parquet writes transactions as parquet files partitioned by bank, year
+// & month, laid out as
+//
+//	root/bank=<bank>/year=<yyyy>/month=<mm>/transactions.parquet
+//
+// so tools that understand hive partitioning (DuckDB, pandas / pyarrow)
+// can read the whole directory as one table.
+type Parquet struct {
+	root string
+}
+
+func NewParquet(root string) Store {
+	return &Parquet{root: root}
+}
+
+func (p *Parquet) Write(txns []*domain.Transaction) error {
+	partitions := map[string][]*domain.Transaction{}
+	for _, t := range txns {
+		ts, err := t.Time()
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Join(
+			p.root,
+			"bank="+url.PathEscape(t.Bank),
+			fmt.Sprintf("year=%04d", ts.UTC().Year()),
+			fmt.Sprintf("month=%02d", ts.UTC().Month()),
+		)
+		partitions[dir] = append(partitions[dir], t)
+	}
+
+	for dir, partition := range partitions {
+		err := p.writePartition(dir, partition)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writePartition merges transactions into a partition's file, replacing
+// rows with the same account & ID so re-runs don't duplicate data.
+func (p *Parquet) writePartition(dir string, txns []*domain.Transaction) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(dir, parquetFilename)
+
+	existing, err := parquet.ReadFile[parquetTransaction](filename)
+	if os.IsNotExist(err) {
+		existing = []parquetTransaction{}
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+
+	rows := map[[2]string]parquetTransaction{}
+	for _, row := range existing {
+		rows[[2]string{row.Account, row.ID}] = row
+	}
+	for _, t := range txns {
+		row, err := toParquet(t)
+		if err != nil {
+			return err
+		}
+		rows[[2]string{row.Account, row.ID}] = row
+	}
+
+	sorted := []parquetTransaction{}
+	for _, row := range rows {
+		sorted = append(sorted, row)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].Timestamp.Equal(sorted[j].Timestamp) {
+			return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+		}
+		if sorted[i].Account != sorted[j].Account {
+			return sorted[i].Account < sorted[j].Account
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	return fileutil.StreamAtomic(filename, 0644, func(w io.Writer) error {
+		return parquet.Write(w, sorted, parquet.Compression(&parquet.Snappy))
+	})
+}
+
+func toParquet(t *domain.Transaction) (parquetTransaction, error) {
+	ts, err := t.Time()
+	if err != nil {
+		return parquetTransaction{}, err
+	}
+
+	tags := t.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	var balance *int64
+	if t.RunningBalance != nil {
+		scaled := parquetDecimal(*t.RunningBalance)
+		balance = &scaled
+	}
+
+	return parquetTransaction{
+		ID:                   t.ID,
+		Account:              t.Account,
+		Currency:             t.Currency,
+		Timestamp:            ts.UTC(),
+		Description:          t.Description,
+		Amount:               parquetDecimal(t.Amount),
+		Type:                 t.Type,
+		Category:             t.Category,
+		Merchant:             t.Merchant,
+		Tags:                 tags,
+		RunningBalance:       balance,
+		Counterparty:         t.Counterparty,
+		Reference:            t.Reference,
+		ProviderID:           t.ProviderID,
+		NormalisedProviderID: t.NormalisedProviderID,
+		Extra:                t.Extra,
+	}, nil
+}
+
+// parquetDecimal scales an amount to our fixed number of decimal places
+func parquetDecimal(amount float64) int64 {
+	return int64(math.Round(amount * math.Pow10(parquetScale)))
+}
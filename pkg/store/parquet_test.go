@@ -0,0 +1,49 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+func TestParquetWritePartitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	pq := NewParquet(dir)
+	balance := 100.5
+
+	assert.Nil(t, pq.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "1", Bank: "My Bank", Account: "x", Timestamp: "2020-07-01T00:00:00Z", Amount: -12.34, Tags: []string{"a", "b"}},
+		&domain.Transaction{ID: "2", Bank: "My Bank", Account: "x", Timestamp: "2020-08-01T00:00:00Z", Amount: 1},
+	}))
+	assert.Nil(t, pq.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "1", Bank: "My Bank", Account: "x", Timestamp: "2020-07-01T00:00:00Z", Amount: -12.34, Tags: []string{"a", "b"}},
+		&domain.Transaction{ID: "3", Bank: "My Bank", Account: "x", Timestamp: "2020-07-02T00:00:00Z", Amount: 2,
+			RunningBalance: &balance, Counterparty: "Someone", Extra: map[string]string{"card_number": "1234"}},
+	}))
+
+	rows, err := parquet.ReadFile[parquetTransaction](filepath.Join(dir, "bank=My%20Bank", "year=2020", "month=07", parquetFilename))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, "1", rows[0].ID)
+	assert.Equal(t, int64(-123400), rows[0].Amount)
+	assert.Equal(t, []string{"a", "b"}, rows[0].Tags)
+	assert.True(t, rows[0].Timestamp.Equal(time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Nil(t, rows[0].RunningBalance)
+	assert.Equal(t, "3", rows[1].ID)
+	assert.Equal(t, int64(1005000), *rows[1].RunningBalance)
+	assert.Equal(t, "Someone", rows[1].Counterparty)
+	assert.Equal(t, map[string]string{"card_number": "1234"}, rows[1].Extra)
+
+	rows, err = parquet.ReadFile[parquetTransaction](filepath.Join(dir, "bank=My%20Bank", "year=2020", "month=08", parquetFilename))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rows))
+}
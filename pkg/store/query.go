@@ -0,0 +1,114 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+// Query selects transactions from a Reader. Zero values match everything.
+type Query struct {
+	Bank    string
+	Account string
+
+	// From is inclusive, To is exclusive
+	From time.Time
+	To   time.Time
+
+	// Text is matched case insensitively against description & merchant
+	Text string
+
+	Category string
+
+	// Limit caps the number of results, 0 means no limit
+	Limit int
+}
+
+// Match returns if the transaction satisfies the query
+func (q *Query) Match(t *domain.Transaction) bool {
+	if q.Bank != "" && q.Bank != t.Bank {
+		return false
+	}
+	if q.Account != "" && q.Account != t.Account {
+		return false
+	}
+	if q.Category != "" && q.Category != t.Category {
+		return false
+	}
+	if q.Text != "" {
+		text := strings.ToLower(q.Text)
+		if !strings.Contains(strings.ToLower(t.Description), text) && !strings.Contains(strings.ToLower(t.Merchant), text) {
+			return false
+		}
+	}
+	if q.From.IsZero() && q.To.IsZero() {
+		return true
+	}
+
+	ts, err := t.Time()
+	if err != nil {
+		return false
+	}
+	if !q.From.IsZero() && ts.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !ts.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// filterTransactions applies a query to transactions held in memory
+func filterTransactions(q *Query, txns []*domain.Transaction) []*domain.Transaction {
+	found := []*domain.Transaction{}
+	for _, t := range txns {
+		if q.Match(t) {
+			found = append(found, t)
+		}
+	}
+
+	sortTransactions(found)
+
+	if q.Limit > 0 && len(found) > q.Limit {
+		found = found[:q.Limit]
+	}
+	return found
+}
+
+// latestTransactions returns the most recent transaction for each bank account
+func latestTransactions(txns []*domain.Transaction) []*domain.Transaction {
+	latest := map[[2]string]*domain.Transaction{}
+	found := []*domain.Transaction{}
+
+	sortTransactions(txns)
+	for _, t := range txns {
+		key := [2]string{t.Bank, t.Account}
+		if _, ok := latest[key]; !ok {
+			found = append(found, t)
+		}
+		latest[key] = t
+	}
+
+	for i, t := range found {
+		found[i] = latest[[2]string{t.Bank, t.Account}]
+	}
+	return found
+}
+
+// sortTransactions orders transactions oldest first
+func sortTransactions(txns []*domain.Transaction) {
+	times := map[*domain.Transaction]time.Time{}
+	for _, t := range txns {
+		times[t], _ = t.Time()
+	}
+
+	sort.SliceStable(txns, func(i, j int) bool {
+		a, b := times[txns[i]], times[txns[j]]
+		if a.Equal(b) {
+			return txns[i].ID < txns[j].ID
+		}
+		return a.Before(b)
+	})
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// columns shared by the SQL stores, in the order they're selected
+const sqlColumns = "id, bank, account, currency, timestamp, description, amount, type, category, merchant, tags, " +
+	"running_balance, counterparty, reference, provider_id, normalised_provider_id, extra"
+
+// sqlDialect captures the differences between the SQL stores that matter
+// when building queries
+type sqlDialect struct {
+	// placeholder renders the nth (1 indexed) query argument
+	placeholder func(n int) string
+
+	// like is the case insensitive LIKE operator
+	like string
+
+	// timeArg converts a time into a query argument
+	timeArg func(time.Time) interface{}
+}
+
+// query builds a SELECT for the given query, returning the SQL and args
+func (d *sqlDialect) query(q *Query) (string, []interface{}) {
+	where := []string{}
+	args := []interface{}{}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return d.placeholder(len(args))
+	}
+
+	if q.Bank != "" {
+		where = append(where, "bank = "+arg(q.Bank))
+	}
+	if q.Account != "" {
+		where = append(where, "account = "+arg(q.Account))
+	}
+	if q.Category != "" {
+		where = append(where, "category = "+arg(q.Category))
+	}
+	if !q.From.IsZero() {
+		where = append(where, "timestamp >= "+arg(d.timeArg(q.From)))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "timestamp < "+arg(d.timeArg(q.To)))
+	}
+	if q.Text != "" {
+		pattern := sqlLikePattern(q.Text)
+		where = append(where, fmt.Sprintf(
+			`(description %s %s ESCAPE '\' OR merchant %s %s ESCAPE '\')`,
+			d.like, arg(pattern), d.like, arg(pattern),
+		))
+	}
+
+	sql := "SELECT " + sqlColumns + " FROM transactions"
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY timestamp, id"
+	if q.Limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+
+	return sql, args
+}
+
+// latest builds a SELECT for the most recent transaction of each account
+func (d *sqlDialect) latest() string {
+	return fmt.Sprintf(`SELECT %s FROM (
+		SELECT %s, ROW_NUMBER() OVER (PARTITION BY bank, account ORDER BY timestamp DESC, id DESC) AS n
+		FROM transactions
+	) latest WHERE n = 1 ORDER BY bank, account`, sqlColumns, sqlColumns)
+}
+
+// sqlLikePattern returns a LIKE pattern matching s anywhere in a string
+func sqlLikePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// sqlExtra returns a transaction's extras as a JSON object
+func sqlExtra(extra map[string]string) (string, error) {
+	if extra == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(extra)
+	return string(data), err
+}
+
+// sqlReadExtra reads extras stored as a JSON object, an empty object gives
+// nil (as the transaction was written with)
+func sqlReadExtra(data []byte) (map[string]string, error) {
+	extra := map[string]string{}
+	err := json.Unmarshal(data, &extra)
+	if err != nil || len(extra) == 0 {
+		return nil, err
+	}
+	return extra, nil
+}
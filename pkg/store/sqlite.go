@@ -0,0 +1,376 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+
+	_ "modernc.org/sqlite" // pure Go, no cgo required
+)
+
+const (
+	sqliteDriver = "sqlite"
+
+	// sqlTimestamp is how we store timestamps, UTC so that they sort as text
+	sqlTimestamp = "2006-01-02T15:04:05Z"
+)
+
+// sqliteMigrations are applied in order, the database records how many
+// have been applied (PRAGMA user_version) so only new ones are run.
+// Never edit a released migration, add a new one.
+var sqliteMigrations = []string{
+	`CREATE TABLE transactions (
+		id          TEXT PRIMARY KEY,
+		bank        TEXT NOT NULL,
+		account     TEXT NOT NULL,
+		currency    TEXT NOT NULL DEFAULT '',
+		timestamp   TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		amount      REAL NOT NULL DEFAULT 0,
+		type        TEXT NOT NULL DEFAULT '',
+		category    TEXT NOT NULL DEFAULT '',
+		merchant    TEXT NOT NULL DEFAULT '',
+		tags        TEXT NOT NULL DEFAULT '[]'
+	);
+	CREATE INDEX transactions_by_account ON transactions (bank, account, timestamp);
+	CREATE INDEX transactions_by_time ON transactions (timestamp);
+
+	CREATE TABLE accounts (
+		bank       TEXT NOT NULL,
+		account    TEXT NOT NULL,
+		currency   TEXT NOT NULL DEFAULT '',
+		first_seen TEXT NOT NULL,
+		last_seen  TEXT NOT NULL,
+		PRIMARY KEY (bank, account)
+	);
+
+	CREATE TABLE balances (
+		bank      TEXT NOT NULL,
+		account   TEXT NOT NULL,
+		timestamp TEXT NOT NULL,
+		currency  TEXT NOT NULL DEFAULT '',
+		amount    REAL NOT NULL,
+		PRIMARY KEY (bank, account, timestamp)
+	);
+
+	CREATE TABLE sync_state (
+		connection TEXT NOT NULL,
+		bank       TEXT NOT NULL,
+		account    TEXT NOT NULL,
+		synced_from TEXT NOT NULL DEFAULT '',
+		synced_to   TEXT NOT NULL DEFAULT '',
+		high_water  TEXT NOT NULL DEFAULT '',
+		updated     TEXT NOT NULL,
+		PRIMARY KEY (connection, bank, account)
+	);`,
+
+	// full provider payloads
+	`ALTER TABLE transactions ADD COLUMN running_balance REAL;
+	ALTER TABLE transactions ADD COLUMN counterparty TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN reference TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN provider_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN normalised_provider_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE transactions ADD COLUMN extra TEXT NOT NULL DEFAULT '{}';
+	CREATE INDEX transactions_by_provider_id ON transactions (normalised_provider_id);`,
+}
+
+const (
+	sqliteUpsertTransaction = `INSERT INTO transactions
+		(id, bank, account, currency, timestamp, description, amount, type, category, merchant, tags,
+		running_balance, counterparty, reference, provider_id, normalised_provider_id, extra)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			bank = excluded.bank,
+			account = excluded.account,
+			currency = excluded.currency,
+			timestamp = excluded.timestamp,
+			description = excluded.description,
+			amount = excluded.amount,
+			type = excluded.type,
+			category = excluded.category,
+			merchant = excluded.merchant,
+			tags = excluded.tags,
+			running_balance = excluded.running_balance,
+			counterparty = excluded.counterparty,
+			reference = excluded.reference,
+			provider_id = excluded.provider_id,
+			normalised_provider_id = excluded.normalised_provider_id,
+			extra = excluded.extra`
+
+	sqliteUpsertAccount = `INSERT INTO accounts
+		(bank, account, currency, first_seen, last_seen)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (bank, account) DO UPDATE SET
+			currency = excluded.currency,
+			first_seen = min(first_seen, excluded.first_seen),
+			last_seen = max(last_seen, excluded.last_seen)`
+
+	sqliteUpsertBalance = `INSERT INTO balances
+		(bank, account, timestamp, currency, amount)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (bank, account, timestamp) DO UPDATE SET
+			currency = excluded.currency,
+			amount = excluded.amount`
+
+	sqliteUpsertSyncState = `INSERT INTO sync_state
+		(connection, bank, account, synced_from, synced_to, high_water, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (connection, bank, account) DO UPDATE SET
+			synced_from = excluded.synced_from,
+			synced_to = excluded.synced_to,
+			high_water = excluded.high_water,
+			updated = excluded.updated`
+)
+
+// check it meets the interfaces
+var (
+	_ Reader       = &SQLite{}
+	_ SyncRecorder = &SQLite{}
+)
+
+var sqliteDialect = &sqlDialect{
+	placeholder: func(int) string { return "?" },
+	like:        "LIKE", // case insensitive for ASCII
+	timeArg:     func(t time.Time) interface{} { return t.UTC().Format(sqlTimestamp) },
+}
+
+// SQLite stores transactions in a local SQLite database file.
+type SQLite struct {
+	filename string
+}
+
+func NewSQLite(filename string) Store {
+	return &SQLite{filename: filename}
+}
+
+// open connects to the database & brings the schema up to date
+func (s *SQLite) open() (*sql.DB, error) {
+	db, err := sql.Open(sqliteDriver, s.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only permits one writer at a time anyway
+	db.SetMaxOpenConns(1)
+
+	err = sqliteMigrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (s *SQLite) Write(txns []*domain.Transaction) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	upsertTxn, err := tx.Prepare(sqliteUpsertTransaction)
+	if err != nil {
+		return err
+	}
+	defer upsertTxn.Close()
+
+	upsertAcc, err := tx.Prepare(sqliteUpsertAccount)
+	if err != nil {
+		return err
+	}
+	defer upsertAcc.Close()
+
+	upsertBal, err := tx.Prepare(sqliteUpsertBalance)
+	if err != nil {
+		return err
+	}
+	defer upsertBal.Close()
+
+	for _, t := range txns {
+		ts, err := sqlTime(t)
+		if err != nil {
+			return err
+		}
+
+		tags, err := json.Marshal(t.Tags)
+		if err != nil {
+			return err
+		}
+		extra, err := sqlExtra(t.Extra)
+		if err != nil {
+			return err
+		}
+
+		_, err = upsertTxn.Exec(
+			t.ID, t.Bank, t.Account, t.Currency, ts, t.Description,
+			t.Amount, t.Type, t.Category, t.Merchant, string(tags),
+			t.RunningBalance, t.Counterparty, t.Reference, t.ProviderID, t.NormalisedProviderID, extra,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to write transaction %s: %v", t.ID, err)
+		}
+
+		_, err = upsertAcc.Exec(t.Bank, t.Account, t.Currency, ts, ts)
+		if err != nil {
+			return fmt.Errorf("failed to write account %s/%s: %v", t.Bank, t.Account, err)
+		}
+
+		if t.RunningBalance == nil {
+			continue // not every provider (or bank) gives one
+		}
+		_, err = upsertBal.Exec(t.Bank, t.Account, ts, t.Currency, *t.RunningBalance)
+		if err != nil {
+			return fmt.Errorf("failed to write balance %s/%s: %v", t.Bank, t.Account, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RecordSync keeps how far each account has been synced in sync_state
+func (s *SQLite) RecordSync(syncs []*AccountSync) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	updated := time.Now().UTC().Format(sqlTimestamp)
+	for _, sync := range syncs {
+		_, err = tx.Exec(sqliteUpsertSyncState,
+			sync.Connection, sync.Bank, sync.Account,
+			sqliteTime(sync.SyncedFrom), sqliteTime(sync.SyncedTo), sqliteTime(sync.HighWater), updated,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to write sync state %s %s/%s: %v", sync.Connection, sync.Bank, sync.Account, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLite) Query(q *Query) ([]*domain.Transaction, error) {
+	query, args := sqliteDialect.query(q)
+	return s.read(query, args...)
+}
+
+func (s *SQLite) Latest() ([]*domain.Transaction, error) {
+	return s.read(sqliteDialect.latest())
+}
+
+// read runs a query selecting sqlColumns & returns the transactions
+func (s *SQLite) read(query string, args ...interface{}) ([]*domain.Transaction, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	txns := []*domain.Transaction{}
+	for rows.Next() {
+		t := &domain.Transaction{}
+		tags := ""
+		extra := ""
+		balance := sql.NullFloat64{}
+
+		err = rows.Scan(
+			&t.ID, &t.Bank, &t.Account, &t.Currency, &t.Timestamp, &t.Description,
+			&t.Amount, &t.Type, &t.Category, &t.Merchant, &tags,
+			&balance, &t.Counterparty, &t.Reference, &t.ProviderID, &t.NormalisedProviderID, &extra,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(tags), &t.Tags)
+		if err != nil {
+			return nil, err
+		}
+		t.Extra, err = sqlReadExtra([]byte(extra))
+		if err != nil {
+			return nil, err
+		}
+		if balance.Valid {
+			t.RunningBalance = &balance.Float64
+		}
+
+		txns = append(txns, t)
+	}
+
+	return txns, rows.Err()
+}
+
+// sqliteMigrate applies any migrations the database hasn't seen yet
+func sqliteMigrate(db *sql.DB) error {
+	version := 0
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than supported (%d)", version, len(sqliteMigrations))
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(sqliteMigrations[i])
+		if err == nil {
+			// pragmas don't accept placeholders
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply schema migration %d: %v", i+1, err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqliteTime formats a time for storage, empty if it's not set
+func sqliteTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(sqlTimestamp)
+}
+
+// sqlTime returns the transaction time, normalised for storage
+func sqlTime(t *domain.Transaction) (string, error) {
+	ts, err := t.Time()
+	if err != nil {
+		return "", err
+	}
+	return ts.UTC().Format(sqlTimestamp), nil
+}
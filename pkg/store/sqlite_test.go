@@ -0,0 +1,172 @@
+package store
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+func TestSQLiteWriteUpserts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.db")
+	db := NewSQLite(filename)
+
+	assert.Nil(t, db.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "1", Bank: "bank", Account: "acc", Timestamp: "2020-07-01T00:00:00+01:00", Amount: 1},
+		&domain.Transaction{ID: "2", Bank: "bank", Account: "acc", Timestamp: "2020-07-02T00:00:00+00:00", Amount: 2},
+	}))
+	assert.Nil(t, db.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "2", Bank: "bank", Account: "acc", Timestamp: "2020-07-02T00:00:00+00:00", Amount: 3},
+	}))
+
+	conn, err := sql.Open(sqliteDriver, filename)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	count := 0
+	assert.Nil(t, conn.QueryRow("SELECT count(*) FROM transactions").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	amount := 0.0
+	assert.Nil(t, conn.QueryRow("SELECT amount FROM transactions WHERE id = '2'").Scan(&amount))
+	assert.Equal(t, 3.0, amount)
+
+	var first, last string
+	assert.Nil(t, conn.QueryRow("SELECT first_seen, last_seen FROM accounts WHERE bank = 'bank' AND account = 'acc'").Scan(&first, &last))
+	assert.Equal(t, "2020-06-30T23:00:00Z", first)
+	assert.Equal(t, "2020-07-02T00:00:00Z", last)
+
+	version := 0
+	assert.Nil(t, conn.QueryRow("PRAGMA user_version").Scan(&version))
+	assert.Equal(t, len(sqliteMigrations), version)
+}
+
+func TestSQLiteQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db := NewSQLite(filepath.Join(dir, "test.db"))
+	assert.Nil(t, db.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "3", Bank: "a", Account: "x", Timestamp: "2020-07-03T00:00:00Z", Description: "Coffee Shop", Tags: []string{"t"}},
+		&domain.Transaction{ID: "1", Bank: "a", Account: "x", Timestamp: "2020-07-01T00:00:00Z", Merchant: "COFFEE CO", Category: "PURCHASE"},
+		&domain.Transaction{ID: "2", Bank: "a", Account: "y", Timestamp: "2020-07-02T00:00:00Z", Description: "Rent 100%"},
+	}))
+
+	reader := db.(Reader)
+
+	found, err := reader.Query(&Query{Text: "coffee"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(found))
+	assert.Equal(t, "1", found[0].ID)
+	assert.Equal(t, []string{"t"}, found[1].Tags)
+
+	found, err = reader.Query(&Query{Text: "0%"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found))
+
+	found, err = reader.Query(&Query{
+		Bank:  "a",
+		From:  time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC),
+		Limit: 1,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(found))
+	assert.Equal(t, "2", found[0].ID)
+
+	latest, err := reader.Latest()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(latest))
+	assert.Equal(t, "3", latest[0].ID)
+	assert.Equal(t, "2", latest[1].ID)
+}
+
+func TestSQLiteMigratesProviderFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.db")
+
+	// a database from before the provider fields were added
+	conn, err := sql.Open(sqliteDriver, filename)
+	assert.Nil(t, err)
+	_, err = conn.Exec(sqliteMigrations[0] + `
+		INSERT INTO transactions (id, bank, account, timestamp) VALUES ('old', 'a', 'x', '2020-07-01T00:00:00Z');
+		PRAGMA user_version = 1;`)
+	assert.Nil(t, err)
+	conn.Close()
+
+	balance := 0.0
+	db := NewSQLite(filename)
+	assert.Nil(t, db.Write([]*domain.Transaction{
+		&domain.Transaction{
+			ID: "new", Bank: "a", Account: "x", Timestamp: "2020-07-02T00:00:00Z",
+			RunningBalance: &balance, Counterparty: "Landlord", Reference: "RENT",
+			ProviderID: "p1", NormalisedProviderID: "n1", Extra: map[string]string{"card_number": "1234"},
+		},
+	}))
+
+	found, err := db.(Reader).Query(&Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(found))
+
+	assert.Nil(t, found[0].RunningBalance)
+	assert.Nil(t, found[0].Extra)
+
+	assert.Equal(t, 0.0, *found[1].RunningBalance)
+	assert.Equal(t, "Landlord", found[1].Counterparty)
+	assert.Equal(t, "RENT", found[1].Reference)
+	assert.Equal(t, "p1", found[1].ProviderID)
+	assert.Equal(t, "n1", found[1].NormalisedProviderID)
+	assert.Equal(t, map[string]string{"card_number": "1234"}, found[1].Extra)
+}
+
+func TestSQLiteBalancesAndSyncState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "beancounter")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.db")
+
+	one, two := 10.5, 7.25
+	db := NewSQLite(filename)
+	assert.Nil(t, db.Write([]*domain.Transaction{
+		&domain.Transaction{ID: "1", Bank: "a", Account: "x", Currency: "GBP", Timestamp: "2020-07-01T00:00:00Z", RunningBalance: &one},
+		&domain.Transaction{ID: "2", Bank: "a", Account: "x", Currency: "GBP", Timestamp: "2020-07-02T00:00:00Z", RunningBalance: &two},
+		&domain.Transaction{ID: "3", Bank: "a", Account: "y", Timestamp: "2020-07-02T00:00:00Z"},
+	}))
+
+	synced := time.Date(2020, 7, 3, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, db.(SyncRecorder).RecordSync([]*AccountSync{
+		&AccountSync{Connection: "home", Bank: "a", Account: "x", SyncedFrom: synced.AddDate(0, 0, -90), SyncedTo: synced, HighWater: synced.AddDate(0, 0, -1)},
+		&AccountSync{Connection: "home", Bank: "a", Account: "y", SyncedTo: synced},
+	}))
+	assert.Nil(t, db.(SyncRecorder).RecordSync([]*AccountSync{
+		&AccountSync{Connection: "home", Bank: "a", Account: "y", SyncedTo: synced.AddDate(0, 0, 1)},
+	}))
+
+	conn, err := sql.Open(sqliteDriver, filename)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	count := 0
+	assert.Nil(t, conn.QueryRow("SELECT count(*) FROM balances").Scan(&count))
+	assert.Equal(t, 2, count)
+	amount := 0.0
+	assert.Nil(t, conn.QueryRow("SELECT amount FROM balances WHERE timestamp = '2020-07-02T00:00:00Z'").Scan(&amount))
+	assert.Equal(t, 7.25, amount)
+
+	var from, to, high string
+	assert.Nil(t, conn.QueryRow("SELECT synced_from, synced_to, high_water FROM sync_state WHERE account = 'x'").Scan(&from, &to, &high))
+	assert.Equal(t, []string{"2020-04-04T00:00:00Z", "2020-07-03T00:00:00Z", "2020-07-02T00:00:00Z"}, []string{from, to, high})
+	assert.Nil(t, conn.QueryRow("SELECT synced_from, synced_to FROM sync_state WHERE account = 'y'").Scan(&from, &to))
+	assert.Equal(t, []string{"", "2020-07-04T00:00:00Z"}, []string{from, to})
+}
@@ -0,0 +1,298 @@
+package syncstate
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/provider"
+)
+
+const (
+	// DefaultChunk is the most of an account's window fetched in one go
+	DefaultChunk = 180 * 24 * time.Hour
+
+	// DefaultOverlap is how far before an account's high water incremental
+	// fetches start, to pick up transactions banks add late
+	DefaultOverlap = 7 * 24 * time.Hour
+
+	// providers work in days, so we don't fetch less than a day
+	day = 24 * time.Hour
+)
+
+// Window is what to fetch for each account
+type Window struct {
+	From time.Time
+	To   time.Time
+
+	// Chunk is the most of an account's window fetched in one go, each
+	// chunk is kept as soon as it's fetched (0 for the whole window)
+	Chunk time.Duration
+
+	// Incremental fetches start from an account's high water (less
+	// Overlap) rather than From, once it's been synced
+	Incremental bool
+	Overlap     time.Duration
+}
+
+// FetchError lists the chunks that couldn't be fetched. They're tried
+// again by the next Fetch, the rest needn't be.
+type FetchError struct {
+	Failures []*Chunk
+}
+
+func (e *FetchError) Error() string {
+	lines := []string{fmt.Sprintf("failed to fetch %d chunks", len(e.Failures))}
+	for _, ch := range e.Failures {
+		lines = append(lines, fmt.Sprintf("%s %s %s to %s: %s", ch.Bank, ch.Name, date(ch.From), date(ch.To), ch.Error))
+	}
+	return strings.Join(lines, "\n\t")
+}
+
+// Fetch returns the transactions of each account over the window, fetched
+// a chunk at a time & kept as they come in. If a previous fetch was
+// interrupted (or failed part way) its chunks are picked up where it
+// stopped, fetching only what's missing. If chunks fail, the transactions
+// of those that didn't are returned along with a *FetchError.
+//
+// Call Complete once the transactions are written out.
+func (c *Connection) Fetch(p provider.AccountProvider, tkn *domain.Token, w *Window) ([]*domain.Transaction, error) {
+	if c.Run != nil && c.pending() == 0 {
+		// we died after fetching everything, but before it was written
+		fmt.Println("sync started", c.Run.Started.Format(time.RFC3339), "was fetched but not written, using it")
+		return c.fetched()
+	}
+
+	accounts, err := p.Accounts(tkn)
+	if err != nil {
+		return nil, err
+	}
+	err = c.plan(accounts, w)
+	if err != nil {
+		return nil, err
+	}
+
+	// accounts are fetched at once, each a chunk at a time
+	byAccount := map[string][]*Chunk{}
+	for _, ch := range c.Run.Chunks {
+		if !ch.Done {
+			byAccount[ch.ID] = append(byAccount[ch.ID], ch)
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, chunks := range byAccount {
+		wg.Add(1)
+		go func(chunks []*Chunk) {
+			defer wg.Done()
+			for _, ch := range chunks {
+				err := c.fetchChunk(p, tkn, ch)
+				if err != nil {
+					fmt.Println("failed to fetch", ch.Bank, ch.Name, date(ch.From), "to", date(ch.To)+":", err)
+				}
+			}
+		}(chunks)
+	}
+	wg.Wait()
+
+	txns, err := c.fetched()
+	if err != nil {
+		return nil, err
+	}
+
+	failed := []*Chunk{}
+	for _, ch := range c.Run.Chunks {
+		if !ch.Done {
+			failed = append(failed, ch)
+		}
+	}
+	if len(failed) > 0 {
+		return txns, &FetchError{Failures: failed}
+	}
+	return txns, nil
+}
+
+// fetchChunk fetches a chunk & keeps its transactions, or records why we
+// couldn't
+func (c *Connection) fetchChunk(p provider.AccountProvider, tkn *domain.Token, ch *Chunk) error {
+	acc := ch.Account
+	txns, err := p.AccountTransactions(tkn, &acc, ch.From, ch.To)
+	if err != nil {
+		serr := c.failChunk(ch, err)
+		if serr != nil {
+			fmt.Println("failed to save sync state:", serr)
+		}
+		return err
+	}
+	return c.writeChunk(ch, txns)
+}
+
+// Complete records that the transactions returned by Fetch have been
+// written out. Accounts whose chunks were all fetched move their synced
+// window & high water on. If every chunk was fetched the run is finished &
+// its chunk files removed, otherwise all of them are kept for the next
+// Fetch; it returns every account's transactions again, as outputs that
+// replace their contents would otherwise be left with only those retried.
+func (c *Connection) Complete() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.Run == nil {
+		return nil
+	}
+
+	pending := map[string]bool{}
+	for _, ch := range c.Run.Chunks {
+		if !ch.Done {
+			pending[ch.ID] = true
+		}
+	}
+
+	for _, ch := range c.Run.Chunks {
+		if pending[ch.ID] {
+			continue
+		}
+
+		acc, ok := c.Accounts[ch.ID]
+		if !ok {
+			acc = &Account{}
+			c.Accounts[ch.ID] = acc
+		}
+		acc.Account = ch.Account // names can change
+		if acc.SyncedFrom.IsZero() || ch.From.Before(acc.SyncedFrom) {
+			acc.SyncedFrom = ch.From
+		}
+		if ch.To.After(acc.SyncedTo) {
+			acc.SyncedTo = ch.To
+		}
+		if ch.Newest.After(acc.HighWater) {
+			acc.HighWater = ch.Newest
+		}
+	}
+
+	done := c.Run.Chunks
+	if len(pending) == 0 {
+		c.Run = nil
+	}
+	err := c.save()
+	if err != nil || c.Run != nil {
+		return err
+	}
+
+	// only once the state no longer needs them
+	for _, ch := range done {
+		os.Remove(c.chunkFile(ch))
+	}
+	return nil
+}
+
+// plan works out the chunks to fetch for each account, adding to those of
+// an unfinished run. Accounts the run has chunks for are fetched on from
+// where their chunks end, others are fetched as the window says.
+func (c *Connection) plan(accounts []*provider.Account, w *Window) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	resuming := c.Run != nil
+	if !resuming {
+		c.Run = &Run{Started: time.Now().UTC()}
+	} else {
+		fmt.Println("resuming sync started", c.Run.Started.Format(time.RFC3339)+",", c.pendingLocked(), "of", len(c.Run.Chunks), "chunks still to fetch")
+	}
+
+	ends := map[string]time.Time{}
+	for _, ch := range c.Run.Chunks {
+		if ch.To.After(ends[ch.ID]) {
+			ends[ch.ID] = ch.To
+		}
+	}
+
+	for _, acc := range accounts {
+		synced, hasSynced := c.Accounts[acc.ID]
+		_, inRun := ends[acc.ID]
+		from := w.From
+		if inRun {
+			from = ends[acc.ID]
+		} else if hasSynced && w.Incremental {
+			since := synced.HighWater
+			if since.IsZero() {
+				since = synced.SyncedTo
+			}
+			if !since.IsZero() && since.Add(-w.Overlap).After(from) {
+				from = since.Add(-w.Overlap)
+			}
+		}
+
+		if inRun && w.To.Sub(from) < day {
+			continue // fetched up to (about) now already
+		}
+		if !from.Before(w.To) {
+			continue
+		}
+
+		for start := from; ; {
+			end := w.To
+			if w.Chunk > 0 && start.Add(w.Chunk).Before(w.To) {
+				end = start.Add(w.Chunk)
+			}
+			c.Run.Chunks = append(c.Run.Chunks, &Chunk{Account: *acc, From: start, To: end})
+			if !end.Before(w.To) {
+				break
+			}
+			start = end
+		}
+	}
+
+	return c.save()
+}
+
+// pending returns how many chunks of our run are still to be fetched
+func (c *Connection) pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.pendingLocked()
+}
+
+func (c *Connection) pendingLocked() int {
+	count := 0
+	for _, ch := range c.Run.Chunks {
+		if !ch.Done {
+			count++
+		}
+	}
+	return count
+}
+
+// fetched returns the transactions of the chunks of our run that have been
+// fetched. Chunks can overlap by a day, so a transaction is only returned
+// once.
+func (c *Connection) fetched() ([]*domain.Transaction, error) {
+	seen := map[[3]string]bool{}
+	txns := []*domain.Transaction{}
+
+	for _, ch := range c.Run.Chunks {
+		if !ch.Done {
+			continue
+		}
+		found, err := c.readChunk(ch)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range found {
+			key := [3]string{t.Bank, t.Account, t.ID}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			txns = append(txns, t)
+		}
+	}
+	return txns, nil
+}
+
+func date(t time.Time) string {
+	return t.Format("2006-01-02")
+}
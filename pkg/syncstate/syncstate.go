@@ -0,0 +1,204 @@
+/*Sync state; how far each account has synced & checkpoints of syncs in progress*/
+package syncstate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/fileutil"
+	"github.com/voidshard/beancounter/pkg/provider"
+	"github.com/voidshard/beancounter/pkg/store"
+)
+
+const (
+	stateFilename = "state.json"
+	chunkDir      = "chunks"
+)
+
+// Account is how far an account has been synced
+type Account struct {
+	provider.Account
+
+	// SyncedFrom & SyncedTo are the window we've fetched & written
+	SyncedFrom time.Time `json:"synced_from,omitzero"`
+	SyncedTo   time.Time `json:"synced_to,omitzero"`
+
+	// HighWater is the time of the newest transaction we've written
+	HighWater time.Time `json:"high_water,omitzero"`
+}
+
+// Chunk is part of an account's window, fetched in one go
+type Chunk struct {
+	provider.Account
+
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Done is set once the chunk's transactions are fetched & kept in our
+	// chunk dir
+	Done  bool `json:"done"`
+	Count int  `json:"count"`
+
+	// Newest is the time of the newest transaction in the chunk
+	Newest time.Time `json:"newest,omitzero"`
+
+	// Error is why the last attempt to fetch the chunk failed
+	Error string `json:"error,omitempty"`
+}
+
+// Run is a sync in progress; the chunks it's made of & how far it's got
+type Run struct {
+	Started time.Time `json:"started"`
+	Chunks  []*Chunk  `json:"chunks"`
+}
+
+// Connection is the sync state of a connection, kept in its own dir
+//
+//	dir/state.json
+//	dir/chunks/<hash>.json  transactions fetched by a run, not yet written
+//
+// Chunk files hold transactions in full, so the dir is private to us & they
+// can be sealed with a cipher. They're removed as soon as they're written
+// out.
+type Connection struct {
+	// Accounts by provider account ID
+	Accounts map[string]*Account `json:"accounts"`
+
+	// Run is the sync in progress, if any
+	Run *Run `json:"run,omitempty"`
+
+	dir    string
+	cipher store.Cipher
+	lock   sync.Mutex
+}
+
+// DefaultDir returns where sync state is kept by default,
+// $XDG_STATE_HOME/beancounter (~/.local/state/beancounter)
+func DefaultDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "state"
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "beancounter")
+}
+
+// Open returns the sync state kept in dir, which is created if need be.
+// If cipher isn't nil, chunk files are sealed with it.
+func Open(dir string, cipher store.Cipher) (*Connection, error) {
+	err := os.MkdirAll(filepath.Join(dir, chunkDir), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Connection{Accounts: map[string]*Account{}, dir: dir, cipher: cipher}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, stateFilename))
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state %s: %v", dir, err)
+	}
+	if c.Accounts == nil {
+		c.Accounts = map[string]*Account{}
+	}
+	return c, nil
+}
+
+// save writes our state, the caller holds our lock
+func (c *Connection) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(filepath.Join(c.dir, stateFilename), data, 0600)
+}
+
+// chunkFile returns where a chunk's transactions are kept
+func (c *Connection) chunkFile(ch *Chunk) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s", ch.ID, ch.From.Format(time.RFC3339), ch.To.Format(time.RFC3339))))
+	return filepath.Join(c.dir, chunkDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// writeChunk keeps a chunk's transactions & marks it done
+func (c *Connection) writeChunk(ch *Chunk, txns []*domain.Transaction) error {
+	data, err := json.Marshal(txns)
+	if err != nil {
+		return err
+	}
+	if c.cipher != nil {
+		data, err = c.cipher.Seal(data)
+		if err != nil {
+			return err
+		}
+	}
+	err = fileutil.WriteAtomic(c.chunkFile(ch), data, 0600)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ch.Done = true
+	ch.Error = ""
+	ch.Count = len(txns)
+	ch.Newest = newest(txns)
+	return c.save()
+}
+
+// readChunk returns the transactions kept for a chunk
+func (c *Connection) readChunk(ch *Chunk) ([]*domain.Transaction, error) {
+	data, err := ioutil.ReadFile(c.chunkFile(ch))
+	if err != nil {
+		return nil, err
+	}
+	if c.cipher != nil && c.cipher.Sealed(data) {
+		data, err = c.cipher.Open(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %v", c.chunkFile(ch), err)
+		}
+	}
+
+	txns := []*domain.Transaction{}
+	err = json.Unmarshal(data, &txns)
+	return txns, err
+}
+
+// failChunk records why a chunk couldn't be fetched
+func (c *Connection) failChunk(ch *Chunk, reason error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ch.Error = reason.Error()
+	return c.save()
+}
+
+// newest returns the time of the newest transaction, ignoring any we can't
+// read the time of
+func newest(txns []*domain.Transaction) time.Time {
+	latest := time.Time{}
+	for _, t := range txns {
+		ts, err := t.Time()
+		if err == nil && ts.After(latest) {
+			latest = ts
+		}
+	}
+	return latest
+}
@@ -0,0 +1,170 @@
+package syncstate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/crypto"
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/provider"
+)
+
+// fakeProvider has a transaction per account each day, & can be told to
+// fail for an account
+type fakeProvider struct {
+	accounts []*provider.Account
+	failing  map[string]bool
+
+	lock  sync.Mutex
+	calls []string
+}
+
+func (f *fakeProvider) Transactions(*domain.Token, time.Time, time.Time) ([]*domain.Transaction, error) {
+	return nil, fmt.Errorf("not used")
+}
+
+func (f *fakeProvider) Accounts(*domain.Token) ([]*provider.Account, error) {
+	return f.accounts, nil
+}
+
+func (f *fakeProvider) AccountTransactions(tkn *domain.Token, acc *provider.Account, from, to time.Time) ([]*domain.Transaction, error) {
+	f.lock.Lock()
+	f.calls = append(f.calls, fmt.Sprintf("%s %s %s", acc.ID, date(from), date(to)))
+	f.lock.Unlock()
+
+	if f.failing[acc.ID] {
+		return nil, fmt.Errorf("bank is down")
+	}
+	txns := []*domain.Transaction{}
+	for ts := from; !ts.After(to); ts = ts.Add(day) {
+		txns = append(txns, &domain.Transaction{
+			ID:        date(ts),
+			Bank:      acc.Bank,
+			Account:   acc.Name,
+			Timestamp: ts.Format(time.RFC3339),
+		})
+	}
+	return txns, nil
+}
+
+func (f *fakeProvider) reset() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		accounts: []*provider.Account{
+			{ID: "a1", Bank: "barclays", Name: "current"},
+			{ID: "a2", Bank: "barclays", Name: "savings"},
+		},
+		failing: map[string]bool{},
+	}
+}
+
+func TestFetchResumes(t *testing.T) {
+	dir := t.TempDir()
+	p := newFakeProvider()
+	to := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	w := &Window{From: to.AddDate(0, 0, -20), To: to, Chunk: 8 * day}
+
+	// the second account fails, the first is kept
+	p.failing["a2"] = true
+	state, err := Open(dir, nil)
+	assert.Nil(t, err)
+	txns, err := state.Fetch(p, &domain.Token{}, w)
+	assert.IsType(t, &FetchError{}, err)
+	assert.Len(t, err.(*FetchError).Failures, 3)
+	assert.Contains(t, err.Error(), "barclays savings 2020-06-11 to 2020-06-19: bank is down")
+	assert.Len(t, txns, 21) // chunks overlap by a day, but each is returned once
+	assert.ElementsMatch(t, []string{
+		"a1 2020-06-11 2020-06-19", "a1 2020-06-19 2020-06-27", "a1 2020-06-27 2020-07-01",
+		"a2 2020-06-11 2020-06-19", "a2 2020-06-19 2020-06-27", "a2 2020-06-27 2020-07-01",
+	}, p.reset())
+
+	assert.Nil(t, state.Complete())
+	assert.Equal(t, to, state.Accounts["a1"].HighWater)
+	assert.Equal(t, w.From, state.Accounts["a1"].SyncedFrom)
+	assert.NotContains(t, state.Accounts, "a2")
+
+	// after a restart only what failed is fetched
+	p.failing["a2"] = false
+	state, err = Open(dir, nil)
+	assert.Nil(t, err)
+	assert.Len(t, state.Run.Chunks, 6)
+
+	// everything is returned, so outputs that replace their contents keep
+	// the first account's transactions
+	txns, err = state.Fetch(p, &domain.Token{}, w)
+	assert.Nil(t, err)
+	assert.Len(t, txns, 42)
+	assert.ElementsMatch(t, []string{
+		"a2 2020-06-11 2020-06-19", "a2 2020-06-19 2020-06-27", "a2 2020-06-27 2020-07-01",
+	}, p.reset())
+
+	assert.Nil(t, state.Complete())
+	assert.Nil(t, state.Run)
+	assert.Equal(t, to, state.Accounts["a2"].HighWater)
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, chunkDir))
+	assert.Nil(t, err)
+	assert.Len(t, files, 0)
+}
+
+func TestFetchedNotWritten(t *testing.T) {
+	dir := t.TempDir()
+	p := newFakeProvider()
+	to := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	w := &Window{From: to.AddDate(0, 0, -5), To: to}
+
+	state, err := Open(dir, crypto.Passphrase("hunter2"))
+	assert.Nil(t, err)
+	txns, err := state.Fetch(p, &domain.Token{}, w)
+	assert.Nil(t, err)
+	assert.Len(t, txns, 12)
+	assert.Len(t, p.reset(), 2)
+
+	// chunks are sealed
+	files, err := filepath.Glob(filepath.Join(dir, chunkDir, "*.json"))
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	data, err := ioutil.ReadFile(files[0])
+	assert.Nil(t, err)
+	assert.True(t, crypto.IsSealed(data))
+
+	// we die before writing, so there's nothing to fetch on restart
+	state, err = Open(dir, crypto.Passphrase("hunter2"))
+	assert.Nil(t, err)
+	again, err := state.Fetch(p, &domain.Token{}, w)
+	assert.Nil(t, err)
+	assert.Equal(t, txns, again)
+	assert.Len(t, p.reset(), 0)
+}
+
+func TestFetchIncremental(t *testing.T) {
+	p := newFakeProvider()
+	to := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	state, err := Open(t.TempDir(), nil)
+	assert.Nil(t, err)
+	_, err = state.Fetch(p, &domain.Token{}, &Window{From: to.AddDate(0, 0, -90), To: to})
+	assert.Nil(t, err)
+	assert.Nil(t, state.Complete())
+	p.reset()
+
+	// a week on, we fetch from the high water less overlap
+	later := to.AddDate(0, 0, 7)
+	w := &Window{From: later.AddDate(0, 0, -90), To: later, Incremental: true, Overlap: 2 * day}
+	txns, err := state.Fetch(p, &domain.Token{}, w)
+	assert.Nil(t, err)
+	assert.Len(t, txns, 20)
+	assert.ElementsMatch(t, []string{"a1 2020-06-29 2020-07-08", "a2 2020-06-29 2020-07-08"}, p.reset())
+}
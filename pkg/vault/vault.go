@@ -0,0 +1,153 @@
+/*Encrypted token storage*/
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/voidshard/beancounter/pkg/crypto"
+	"github.com/voidshard/beancounter/pkg/domain"
+	"github.com/voidshard/beancounter/pkg/fileutil"
+)
+
+// ErrNotFound is returned when the vault holds no token by a name
+var ErrNotFound = fmt.Errorf("token not found")
+
+// contents is what's sealed in the vault file
+type contents struct {
+	Tokens map[string]*domain.Token `json:"tokens"`
+}
+
+// Vault keeps provider tokens by name (eg. the connection they're for) in a
+// file sealed with a passphrase (see crypto.Seal).
+type Vault struct {
+	filename   string
+	passphrase []byte
+
+	lock sync.Mutex
+}
+
+// New returns a vault kept in filename, which is created on the first Put
+func New(filename string, passphrase []byte) *Vault {
+	return &Vault{filename: filename, passphrase: passphrase}
+}
+
+// Get returns the named token, or ErrNotFound
+func (v *Vault) Get(name string) (*domain.Token, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	c, err := v.read()
+	if err != nil {
+		return nil, err
+	}
+	tkn, ok := c.Tokens[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return tkn, nil
+}
+
+// Put sets the named token, replacing any we had
+func (v *Vault) Put(name string, tkn *domain.Token) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	c, err := v.read()
+	if err != nil {
+		return err
+	}
+	c.Tokens[name] = tkn
+	return v.write(c, v.passphrase)
+}
+
+// Delete removes the named token, if we have it
+func (v *Vault) Delete(name string) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	c, err := v.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := c.Tokens[name]; !ok {
+		return nil
+	}
+	delete(c.Tokens, name)
+	return v.write(c, v.passphrase)
+}
+
+// Names returns the names of the tokens we hold, sorted
+func (v *Vault) Names() ([]string, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	c, err := v.read()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range c.Tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Rekey seals the vault with a new passphrase
+func (v *Vault) Rekey(passphrase []byte) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	c, err := v.read()
+	if err != nil {
+		return err
+	}
+	err = v.write(c, passphrase)
+	if err != nil {
+		return err
+	}
+	v.passphrase = passphrase
+	return nil
+}
+
+// read opens the vault, an empty one if the file doesn't exist yet
+func (v *Vault) read() (*contents, error) {
+	c := &contents{Tokens: map[string]*domain.Token{}}
+
+	sealed, err := ioutil.ReadFile(v.filename)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err := crypto.Open(sealed, v.passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vault %s: %v", v.filename, err)
+	}
+
+	err = json.Unmarshal(data, c)
+	if c.Tokens == nil {
+		c.Tokens = map[string]*domain.Token{}
+	}
+	return c, err
+}
+
+// write seals the vault & replaces the file, readable only by us
+func (v *Vault) write(c *contents, passphrase []byte) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	sealed, err := crypto.Seal(data, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteAtomic(v.filename, sealed, 0600)
+}
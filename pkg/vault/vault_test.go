@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidshard/beancounter/pkg/crypto"
+	"github.com/voidshard/beancounter/pkg/domain"
+)
+
+func TestVault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tokens.vault")
+
+	v := New(filename, []byte("pass"))
+
+	_, err = v.Get("nope")
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, v.Put("monzo", domain.NewToken("access", "refresh", 60)))
+	assert.Nil(t, v.Put("amex", domain.NewToken("access2", "", 60)))
+
+	info, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.True(t, crypto.IsSealed(data))
+	assert.NotContains(t, string(data), "refresh")
+
+	// someone else opening the same file
+	tkn, err := New(filename, []byte("pass")).Get("monzo")
+	assert.Nil(t, err)
+	assert.Equal(t, "refresh", tkn.Refresh)
+
+	_, err = New(filename, []byte("wrong")).Get("monzo")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, v.Delete("amex"))
+	names, err := v.Names()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"monzo"}, names)
+}
+
+func TestVaultRekey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tokens.vault")
+
+	v := New(filename, []byte("old"))
+	assert.Nil(t, v.Put("monzo", domain.NewToken("access", "refresh", 60)))
+	assert.Nil(t, v.Rekey([]byte("new")))
+
+	_, err = New(filename, []byte("old")).Get("monzo")
+	assert.NotNil(t, err)
+	tkn, err := New(filename, []byte("new")).Get("monzo")
+	assert.Nil(t, err)
+	assert.Equal(t, "access", tkn.Value)
+
+	// & the vault itself carries on with the new passphrase
+	assert.Nil(t, v.Put("amex", domain.NewToken("access2", "", 60)))
+}